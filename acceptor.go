@@ -1,6 +1,7 @@
 package kshaka
 
 import (
+	"bytes"
 	"fmt"
 )
 
@@ -10,18 +11,42 @@ import (
 // mull on this.
 const minimumNoAcceptors = 3
 
-// acceptedBallotKey is the key that we use to store the value of the current accepted Ballot.
+// legacyMigratedMetaKey is the key, in the meta namespace, under which an acceptor records that it has migrated
+// every key that older versions of kshaka laid out in the store; see migrateLegacyState.
+var legacyMigratedMetaKey = []byte("legacyMigrated")
+
+// acceptorStateKey is the key that we used to store the (promised Ballot, accepted Ballot, state) record of key,
+// before the records were kept in the data namespace. It is only read to migrate old stores.
+// it ought to be unique and clients/users will be prohibited from using this value as a key for their data.
+func acceptorStateKey(key []byte) []byte {
+	return []byte(fmt.Sprintf("__ACCEPTOR__State__KEY__6c9a3b0e-c9a1-11f1-8577-02fc00000001__6c9a3c94-c9a1-11f1-8577-02fc00000001.%s", key))
+}
+
+// acceptedBallotKey is the key that we used to store the value of the current accepted Ballot,
+// before the acceptor state of a key was kept in one record. It is only read to migrate old stores.
 // it ought to be unique and clients/users will be prohibited from using this value as a key for their data.
 func acceptedBallotKey(key []byte) []byte {
 	return []byte(fmt.Sprintf("__ACCEPTED__Ballot__KEY__207d1a68-34f3-11e8-88e5-cb7b2fa68526__3a39a980-34f3-11e8-853c-f35df5f3154e.%s", key))
 }
 
-// promisedBallotKey is the key that we use to store the value of the current promised Ballot.
+// promisedBallotKey is the key that we used to store the value of the current promised Ballot,
+// before the acceptor state of a key was kept in one record. It is only read to migrate old stores.
 // it ought to be unique and clients/users will be prohibited from using this value as a key for their data.
 func promisedBallotKey(key []byte) []byte {
 	return []byte(fmt.Sprintf("__PROMISED__Ballot__KEY__c8c07b0c-3598-11e8-98b8-97a4ad1feb35__d1a0ca9c-3598-11e8-9c5f-c3c66e6b4439.%s", key))
 }
 
+// isReservedKey reports whether key is one of the keys that older versions of kshaka stored their own state under.
+// The acceptors may still read them to migrate old stores, so clients cannot propose them.
+func isReservedKey(key []byte) bool {
+	for _, prefix := range [][]byte{acceptorStateKey(nil), acceptedBallotKey(nil), promisedBallotKey(nil)} {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return bytes.Equal(key, ballotCounterKey)
+}
+
 // AcceptorState is the state that is maintained by an acceptor/node
 type AcceptorState struct {
 	PromisedBallot Ballot
@@ -36,4 +61,5 @@ type AcceptorState struct {
 type acceptor interface {
 	Prepare(b Ballot, key []byte) (AcceptorState, error)
 	Accept(b Ballot, key []byte, state []byte) (AcceptorState, error)
+	AcceptPrepare(b Ballot, key []byte, state []byte, next Ballot) (AcceptorState, error)
 }
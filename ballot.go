@@ -4,7 +4,36 @@ package kshaka
 // It’s convenient to use tuples as Ballot numbers.
 // To generate it a proposer combines its numerical ID with a local increasing counter: (counter, ID).
 // To compare Ballot tuples, we should compare the first component of the tuples and use ID only as a tiebreaker.
+// Epoch is the version of the cluster configuration that the proposer was running when it generated the Ballot.
+// It is not part of the ordering of Ballots; acceptors use it to reject proposers running an outdated configuration.
 type Ballot struct {
 	Counter uint64
 	NodeID  uint64
+	Epoch   uint64
+}
+
+// Compare returns -1 if b is less than other, 0 if they are equal and +1 if b is greater than other.
+// Ballots are ordered by their Counter, NodeID is only used as a tie-breaker.
+func (b Ballot) Compare(other Ballot) int {
+	switch {
+	case b.Counter < other.Counter:
+		return -1
+	case b.Counter > other.Counter:
+		return 1
+	case b.NodeID < other.NodeID:
+		return -1
+	case b.NodeID > other.NodeID:
+		return 1
+	}
+	return 0
+}
+
+// Less reports whether b is less than other.
+func (b Ballot) Less(other Ballot) bool {
+	return b.Compare(other) < 0
+}
+
+// Equal reports whether b and other are the same Ballot.
+func (b Ballot) Equal(other Ballot) bool {
+	return b.Compare(other) == 0
 }
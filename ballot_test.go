@@ -0,0 +1,34 @@
+package kshaka
+
+import (
+	"testing"
+)
+
+func TestBallotCompare(t *testing.T) {
+	tests := []struct {
+		name  string
+		b     Ballot
+		other Ballot
+		want  int
+	}{
+		{name: "zero ballots", b: Ballot{}, other: Ballot{}, want: 0},
+		{name: "same ballot", b: Ballot{Counter: 3, NodeID: 2}, other: Ballot{Counter: 3, NodeID: 2}, want: 0},
+		{name: "smaller counter", b: Ballot{Counter: 2, NodeID: 9}, other: Ballot{Counter: 3, NodeID: 1}, want: -1},
+		{name: "greater counter", b: Ballot{Counter: 4, NodeID: 1}, other: Ballot{Counter: 3, NodeID: 9}, want: 1},
+		{name: "equal counter smaller NodeID", b: Ballot{Counter: 3, NodeID: 1}, other: Ballot{Counter: 3, NodeID: 2}, want: -1},
+		{name: "equal counter greater NodeID", b: Ballot{Counter: 3, NodeID: 2}, other: Ballot{Counter: 3, NodeID: 1}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.Compare(tt.other); got != tt.want {
+				t.Errorf("\n Ballot.Compare() \ngot = %#+v, \nwanted = %#+v", got, tt.want)
+			}
+			if got := tt.b.Less(tt.other); got != (tt.want < 0) {
+				t.Errorf("\n Ballot.Less() \ngot = %#+v, \nwanted = %#+v", got, tt.want < 0)
+			}
+			if got := tt.b.Equal(tt.other); got != (tt.want == 0) {
+				t.Errorf("\n Ballot.Equal() \ngot = %#+v, \nwanted = %#+v", got, tt.want == 0)
+			}
+		})
+	}
+}
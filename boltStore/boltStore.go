@@ -0,0 +1,292 @@
+/*
+Package boltStore provides a kshaka StableStore that is backed by go.etcd.io/bbolt.
+
+The store implements kshaka.BucketStore; so a node keeps the acceptor state of client keys and its own metadata
+in two buckets, nested in the bucket of the store. It also implements kshaka.TxnStore; so that an acceptor reads
+the state of a key and writes its new state, for each Prepare and Accept, in a single bolt transaction.
+kshaka.KeyIterator and kshaka.DeleteStore, which membership changes and garbage collection need, are implemented as well;
+IteratePrefix can also be used by tools that inspect a store.
+*/
+package boltStore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/komuw/kshaka"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// DefaultBucket is the bucket that Open keeps the keys of the store in.
+var DefaultBucket = []byte("kshaka")
+
+// Options configures the database opened by Open.
+type Options struct {
+	// NoSync skips the fsync after every commit; see bbolt.DB.NoSync.
+	// It speeds up writes, but gives up the durability that kshaka.StableStore requires.
+	NoSync bool
+	// Batch makes the writes done outside a transaction, eg to the Ballot counter, go through bbolt.DB.Batch;
+	// which combines concurrent writes into one bolt transaction.
+	// Prepare and Accept always run in a transaction of their own.
+	Batch bool
+	// MaxBatchSize and MaxBatchDelay override the defaults of bbolt.DB if they are not zero.
+	MaxBatchSize  int
+	MaxBatchDelay time.Duration
+	// Timeout is how long Open waits for the lock on the database file; see bbolt.Options.Timeout.
+	Timeout time.Duration
+	// FileMode is the mode the database file is created with. It defaults to 0600.
+	FileMode os.FileMode
+}
+
+// Store keeps its keys in a bucket of a bolt database.
+type Store struct {
+	db *bbolt.DB
+	// path is the names of the bucket, and of the buckets that it is nested in.
+	path  [][]byte
+	batch bool
+	// owned is true if the store opened db, and should close it.
+	owned bool
+}
+
+// Open opens, or creates, the bolt database at path and returns a Store that keeps its keys in DefaultBucket.
+// The store owns the database; Close closes it.
+//
+//	store, err := boltStore.Open("/var/lib/kshaka/kshaka.db", boltStore.Options{})
+//	defer store.Close()
+//	node := kshaka.NewNode(1, store)
+func Open(path string, opts Options) (*Store, error) {
+	mode := opts.FileMode
+	if mode == 0 {
+		mode = 0600
+	}
+	db, err := bbolt.Open(path, mode, &bbolt.Options{Timeout: opts.Timeout, NoSync: opts.NoSync})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to open database:%v", path))
+	}
+	if opts.MaxBatchSize != 0 {
+		db.MaxBatchSize = opts.MaxBatchSize
+	}
+	if opts.MaxBatchDelay != 0 {
+		db.MaxBatchDelay = opts.MaxBatchDelay
+	}
+	s, err := New(db, DefaultBucket)
+	if err != nil {
+		db.Close() // nolint: errcheck
+		return nil, err
+	}
+	s.batch = opts.Batch
+	s.owned = true
+	return s, nil
+}
+
+// New returns a Store that keeps its keys in the bucket called bucket of db, and creates the bucket if it does not exist.
+// The caller owns db; and has to close it once the store is no longer used.
+//
+//	db, err := bbolt.Open("/tmp/kshaka.db", 0600, nil)
+//	store, err := boltStore.New(db, []byte("kshaka"))
+//	node := kshaka.NewNode(1, store)
+func New(db *bbolt.DB, bucket []byte) (*Store, error) {
+	s := &Store{db: db, path: [][]byte{bucket}}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := s.createBucket(tx)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to create bucket:%s", bucket))
+	}
+	return s, nil
+}
+
+// Close closes the database, if the store was created by Open.
+func (s *Store) Close() error {
+	if !s.owned {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// Bucket implements the kshaka.BucketStore interface.
+// The bucket is nested in the bucket of s, and is created by the first write to it.
+func (s *Store) Bucket(name []byte) kshaka.StableStore {
+	path := append(append([][]byte{}, s.path...), append([]byte{}, name...))
+	return &Store{db: s.db, path: path, batch: s.batch}
+}
+
+// bucket returns the bucket of the store in tx, or nil if it has not been created yet.
+func (s *Store) bucket(tx *bbolt.Tx) *bbolt.Bucket {
+	b := tx.Bucket(s.path[0])
+	for _, name := range s.path[1:] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket(name)
+	}
+	return b
+}
+
+// createBucket returns the bucket of the store in tx, and creates it if it does not exist.
+func (s *Store) createBucket(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists(s.path[0])
+	for _, name := range s.path[1:] {
+		if err != nil {
+			return nil, err
+		}
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	return b, err
+}
+
+// update runs fn in a read-write transaction; which is a batch if the store was opened with Options.Batch.
+func (s *Store) update(fn func(b *bbolt.Bucket) error) error {
+	run := s.db.Update
+	if s.batch {
+		run = s.db.Batch
+	}
+	return run(func(tx *bbolt.Tx) error {
+		b, err := s.createBucket(tx)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// Set implements the kshaka.StableStore interface.
+func (s *Store) Set(key []byte, val []byte) error {
+	return s.update(func(b *bbolt.Bucket) error {
+		return b.Put(key, val)
+	})
+}
+
+// Get implements the kshaka.StableStore interface.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		val = get(s.bucket(tx), key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		return nil, errors.Wrap(kshaka.ErrNotFound, fmt.Sprintf("key:%v", key))
+	}
+	return val, nil
+}
+
+// SetUint64 implements the kshaka.StableStore interface.
+func (s *Store) SetUint64(key []byte, val uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, val)
+	return s.Set(key, buf)
+}
+
+// GetUint64 implements the kshaka.StableStore interface.
+func (s *Store) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("value of key:%v is %v bytes, not a uint64", key, len(val))
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// Delete implements the kshaka.DeleteStore interface.
+func (s *Store) Delete(key []byte) error {
+	return s.update(func(b *bbolt.Bucket) error {
+		return b.Delete(key)
+	})
+}
+
+// IteratePrefix implements the kshaka.KeyIterator interface.
+// The keys are visited in order, and nested buckets are skipped.
+// The iteration runs inside a read-only transaction, so fn should not write to the store.
+func (s *Store) IteratePrefix(prefix []byte, fn func(key []byte, val []byte) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		b := s.bucket(tx)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if v == nil {
+				// a nested bucket.
+				continue
+			}
+			if err := fn(append([]byte{}, k...), append([]byte{}, v...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Begin implements the kshaka.TxnStore interface.
+// Like every bolt read-write transaction, it blocks until the transaction that is in progress, if any, is committed or rolled back.
+func (s *Store) Begin() (kshaka.Txn, error) {
+	tx, err := s.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.createBucket(tx)
+	if err != nil {
+		tx.Rollback() // nolint: errcheck
+		return nil, err
+	}
+	return &txn{tx: tx, bucket: b}, nil
+}
+
+// txn is a bolt read-write transaction.
+type txn struct {
+	tx     *bbolt.Tx
+	bucket *bbolt.Bucket
+	done   bool
+}
+
+func (t *txn) Get(key []byte) ([]byte, error) {
+	val := get(t.bucket, key)
+	if val == nil {
+		return nil, errors.Wrap(kshaka.ErrNotFound, fmt.Sprintf("key:%v", key))
+	}
+	return val, nil
+}
+
+func (t *txn) Set(key []byte, val []byte) error {
+	return t.bucket.Put(key, val)
+}
+
+func (t *txn) Delete(key []byte) error {
+	return t.bucket.Delete(key)
+}
+
+func (t *txn) Commit() error {
+	t.done = true
+	return t.tx.Commit()
+}
+
+func (t *txn) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	return t.tx.Rollback()
+}
+
+// get returns a copy of the value of key in bucket, or nil if bucket is nil or does not have key.
+// Values returned by bolt are only valid for the life of the transaction.
+func get(bucket *bbolt.Bucket, key []byte) []byte {
+	if bucket == nil {
+		return nil
+	}
+	val := bucket.Get(key)
+	if val == nil {
+		return nil
+	}
+	return append([]byte{}, val...)
+}
@@ -0,0 +1,269 @@
+package boltStore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/komuw/kshaka"
+	"go.etcd.io/bbolt"
+)
+
+// newStore returns a Store in a new database, and a func that removes the database.
+func newStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "boltStore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bbolt.Open(filepath.Join(dir, "kshaka.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		db.Close()        // nolint: errcheck
+		os.RemoveAll(dir) // nolint: errcheck
+	}
+	store, err := New(db, []byte("kshaka"))
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return store, cleanup
+}
+
+func TestStore(t *testing.T) {
+	store, cleanup := newStore(t)
+	defer cleanup()
+	key := []byte("foo")
+
+	if _, err := store.Get(key); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nstore.Get() \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+	if err := store.SetUint64(key, 7); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetUint64(key)
+	if err != nil || got != 7 {
+		t.Errorf("\nstore.GetUint64() \ngot= %v %v, \nwant = %v", got, err, 7)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetUint64(key); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nstore.GetUint64() \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+
+	txn, err := store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nstore.Get() after Rollback \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+
+	txn, err = store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Errorf("\ntxn.Rollback() after Commit \nerr = %v", err)
+	}
+	val, err := store.Get(key)
+	if err != nil || string(val) != "bar" {
+		t.Errorf("\nstore.Get() after Commit \ngot= %s %v, \nwant = %s", val, err, "bar")
+	}
+}
+
+func TestPropose(t *testing.T) {
+	nodes := []*kshaka.Node{}
+	for i := uint64(1); i <= 3; i++ {
+		store, cleanup := newStore(t)
+		defer cleanup()
+		n := kshaka.NewNode(i, store)
+		n.AddTransport(&kshaka.InmemTransport{Node: n})
+		nodes = append(nodes, n)
+	}
+	kshaka.MingleNodes(nodes...)
+
+	key, val := []byte("foo"), []byte("bar")
+	_, err := nodes[0].Propose(key, func(current []byte) ([]byte, error) { return val, nil })
+	if err != nil {
+		t.Fatalf("\nnode.Propose() \nerr = %v", err)
+	}
+	newState, err := nodes[1].Read(key)
+	if err != nil {
+		t.Fatalf("\nnode.Read() \nerr = %v", err)
+	}
+	if string(newState) != string(val) {
+		t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", newState, val)
+	}
+
+	if err := nodes[0].Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	collected, err := nodes[0].CollectGarbage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected != 1 {
+		t.Errorf("\nnode.CollectGarbage() \ngot= %v, \nwant = %v", collected, 1)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltStore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	for _, opts := range []Options{{}, {NoSync: true, Batch: true, MaxBatchDelay: time.Millisecond}} {
+		t.Run(fmt.Sprintf("%+v", opts), func(t *testing.T) {
+			paths := []string{}
+			stores := []*Store{}
+			nodes := []*kshaka.Node{}
+			for i := uint64(1); i <= 3; i++ {
+				path := filepath.Join(dir, fmt.Sprintf("batch-%v-%v.db", opts.Batch, i))
+				store, err := Open(path, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				n := kshaka.NewNode(i, store)
+				n.AddTransport(&kshaka.InmemTransport{Node: n})
+				paths = append(paths, path)
+				stores = append(stores, store)
+				nodes = append(nodes, n)
+			}
+			kshaka.MingleNodes(nodes...)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					key := []byte(fmt.Sprintf("key-%v", i))
+					_, err := nodes[i%3].Propose(key, func(current []byte) ([]byte, error) { return key, nil })
+					if err != nil {
+						t.Errorf("\nnode.Propose() \nerr = %v", err)
+					}
+				}(i)
+			}
+			wg.Wait()
+			for _, store := range stores {
+				if err := store.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// client keys and the node's metadata are kept in buckets of their own, nested in DefaultBucket.
+			// Propose returns once a quorum has replied, so only a quorum of the acceptors is sure to hold each key.
+			holders := map[string]int{}
+			for _, path := range paths {
+				keys, err := checkBuckets(path)
+				if err != nil {
+					t.Errorf("\ndatabase:%v \nerr = %v", path, err)
+				}
+				for _, k := range keys {
+					holders[k]++
+				}
+			}
+			for i := 0; i < 10; i++ {
+				key := fmt.Sprintf("key-%v", i)
+				if holders[key] < 2 {
+					t.Errorf("\nacceptors holding key:%v \ngot= %v, \nwant >= %v", key, holders[key], 2)
+				}
+			}
+		})
+	}
+}
+
+// checkBuckets checks that the database at path only has the data and meta buckets in DefaultBucket, and returns the keys of data.
+func checkBuckets(path string) ([]string, error) {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close() // nolint: errcheck
+	keys := []string{}
+	err = db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(DefaultBucket)
+		if root == nil {
+			return errors.New("no bucket called DefaultBucket")
+		}
+		buckets := []string{}
+		err := root.ForEach(func(k []byte, v []byte) error {
+			if v != nil {
+				return fmt.Errorf("key:%s is outside the buckets", k)
+			}
+			buckets = append(buckets, string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if want := []string{"data", "meta"}; !reflect.DeepEqual(buckets, want) {
+			return fmt.Errorf("got buckets %v, want %v", buckets, want)
+		}
+		return root.Bucket([]byte("data")).ForEach(func(k []byte, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func TestIteratePrefix(t *testing.T) {
+	store, cleanup := newStore(t)
+	defer cleanup()
+	for _, k := range []string{"a/2", "a/1", "b/1"} {
+		if err := store.Set([]byte(k), []byte("val")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// buckets are skipped.
+	if err := store.Bucket([]byte("a/bucket")).Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{}
+	err := store.IteratePrefix([]byte("a/"), func(k []byte, val []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/1", "a/2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("\nstore.IteratePrefix() \ngot= %v, \nwant = %v", keys, want)
+	}
+
+	// a bucket that has not been written to yet is empty.
+	err = store.Bucket([]byte("empty")).(kshaka.KeyIterator).IteratePrefix(nil, func(k []byte, val []byte) error {
+		return fmt.Errorf("unexpected key:%s", k)
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := store.Bucket([]byte("empty")).Get([]byte("foo")); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nbucket.Get() \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+}
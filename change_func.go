@@ -13,5 +13,6 @@ An example ChangeFunction is given below:
 A client can send the above change function to a proposer, when the client wants to read the value stored
 at a key named foo. The proposer will apply that function to the current state(ie to the current value stored at key foo) and return
 the new value stored at that key and an error.
+However, Node.Read is a cheaper way of reading a key since it usually skips the accept phase.
 */
 type ChangeFunction func(currentState []byte) ([]byte, error)
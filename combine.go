@@ -0,0 +1,135 @@
+package kshaka
+
+import (
+	"context"
+)
+
+/*
+Request combining lets a Node run the proposals that its clients make concurrently on the same key as one batch.
+Instead of each proposal going through its own prepare and accept phases, and conflicting with the others,
+the ChangeFunctions that are queued for a key while a batch is in flight are composed, in the order they arrived,
+into one ChangeFunction that the next batch proposes. Each caller gets back the state that its own ChangeFunction produced.
+*/
+
+// combinedRequest is a ChangeFunction that is waiting to be proposed as part of a batch.
+type combinedRequest struct {
+	ctx        context.Context
+	changeFunc ChangeFunction
+	newState   []byte
+	err        error
+	done       chan struct{}
+}
+
+// combinedResult is what one ChangeFunction of a batch produced.
+type combinedResult struct {
+	newState []byte
+	err      error
+}
+
+// combiner queues the requests for one key. Only one batch per key is in flight at a time.
+type combiner struct {
+	pending []*combinedRequest
+}
+
+// EnableRequestCombining makes the node combine the proposals that it is asked to make concurrently on the same key.
+// While a proposal on a key is in flight, further proposals on that key are queued; once it completes,
+// all the queued ChangeFunctions are composed in order and proposed together in a single prepare and accept cycle.
+// Every caller gets back the state that its own ChangeFunction produced, as if the proposals had run one after the other.
+// A ChangeFunction that returns an error does not stop the others in its batch, the state is passed on to the next one unchanged.
+func (n *Node) EnableRequestCombining() {
+	n.combining = true
+}
+
+// proposeCombined queues changeFunc to be proposed in the next batch for key, and waits for that batch.
+func (n *Node) proposeCombined(ctx context.Context, key []byte, changeFunc ChangeFunction) ([]byte, error) {
+	req := &combinedRequest{ctx: ctx, changeFunc: changeFunc, done: make(chan struct{})}
+
+	n.combinersMu.Lock()
+	if n.combiners == nil {
+		n.combiners = map[string]*combiner{}
+	}
+	c, running := n.combiners[string(key)]
+	if !running {
+		c = &combiner{}
+		n.combiners[string(key)] = c
+	}
+	c.pending = append(c.pending, req)
+	n.combinersMu.Unlock()
+	if !running {
+		// the batches may outlive this call, so they get their own copy of key.
+		go n.runBatches(append([]byte{}, key...), c)
+	}
+
+	select {
+	case <-req.done:
+		return req.newState, req.err
+	case <-ctx.Done():
+		// like any cancelled proposal, changeFunc may still be applied.
+		return nil, ctx.Err()
+	}
+}
+
+// runBatches proposes the requests queued in c, one batch at a time, until there are none left.
+func (n *Node) runBatches(key []byte, c *combiner) {
+	for {
+		n.combinersMu.Lock()
+		batch := c.pending
+		c.pending = nil
+		if len(batch) == 0 {
+			delete(n.combiners, string(key))
+			n.combinersMu.Unlock()
+			return
+		}
+		n.combinersMu.Unlock()
+
+		n.runBatch(key, batch)
+	}
+}
+
+// runBatch proposes the composition of the ChangeFunctions in batch.
+// The batch is cancelled once all its callers have given up on it.
+func (n *Node) runBatch(key []byte, batch []*combinedRequest) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		for _, req := range batch {
+			select {
+			case <-req.ctx.Done():
+			case <-finished:
+				return
+			}
+		}
+		cancel()
+	}()
+
+	// the composed ChangeFunction is applied again each time the proposal is retried,
+	// the results of the last application are the ones that got accepted.
+	results := make([]combinedResult, len(batch))
+	composed := func(current []byte) ([]byte, error) {
+		state := current
+		for i, req := range batch {
+			newState, err := req.changeFunc(state)
+			if err != nil {
+				results[i] = combinedResult{err: &ErrChangeFunction{Key: key, Err: err}}
+				continue
+			}
+			state = newState
+			results[i] = combinedResult{newState: newState}
+		}
+		return state, nil
+	}
+
+	_, err := n.withRetries(ctx, n.retryPolicy, "proposal", func() ([]byte, error) {
+		return n.propose(ctx, key, composed)
+	})
+	for i, req := range batch {
+		if err != nil {
+			req.err = err
+		} else {
+			req.newState, req.err = results[i].newState, results[i].err
+		}
+		close(req.done)
+	}
+}
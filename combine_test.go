@@ -0,0 +1,146 @@
+package kshaka
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// incrementFunc is a ChangeFunction that increments the counter stored at a key.
+var incrementFunc ChangeFunction = func(current []byte) ([]byte, error) {
+	counter, _ := strconv.Atoi(string(current))
+	return []byte(strconv.Itoa(counter + 1)), nil
+}
+
+func TestRequestCombining(t *testing.T) {
+	const proposals = 50
+	var accepts int64
+	nodes := []*Node{}
+	for i := uint64(1); i <= 3; i++ {
+		n := NewNode(i, &InmemStore{})
+		n.AddTransport(&acceptCountingTransport{InmemTransport: InmemTransport{Node: n}, accepts: &accepts})
+		// the other nodes have not proposed yet, so their first read conflicts with the batches of nodes[0].
+		n.AddRetryPolicy(RetryPolicy{MaxAttempts: 3})
+		n.EnableRequestCombining()
+		nodes = append(nodes, n)
+	}
+	MingleNodes(nodes...)
+	key := []byte("counter")
+
+	var wg sync.WaitGroup
+	results := make(chan string, proposals)
+	for i := 0; i < proposals; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newState, err := nodes[0].Propose(key, incrementFunc)
+			if err != nil {
+				t.Errorf("\nnode.Propose() \nerr = %v", err)
+				return
+			}
+			results <- string(newState)
+		}()
+	}
+	// a ChangeFunction that fails does not fail the rest of its batch.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := nodes[0].Propose(key, func(current []byte) ([]byte, error) { return nil, errors.New("bad change") })
+		if err == nil {
+			t.Errorf("\nnode.Propose() \nwanted the error of the ChangeFunction")
+		}
+	}()
+	wg.Wait()
+	close(results)
+
+	// every caller got its own intermediate result.
+	seen := map[string]bool{}
+	for r := range results {
+		seen[r] = true
+	}
+	for i := 1; i <= proposals; i++ {
+		if !seen[strconv.Itoa(i)] {
+			t.Errorf("\nno caller got the intermediate result:%v", i)
+		}
+	}
+	val, err := nodes[1].Read(key)
+	if err != nil {
+		t.Fatalf("\nnode.Read() \nerr = %v", err)
+	}
+	if string(val) != strconv.Itoa(proposals) {
+		t.Errorf("\nnode.Read() \ngot= %s, \nwant = %v", val, proposals)
+	}
+	// each batch sends one accept message per acceptor.
+	if batches := atomic.LoadInt64(&accepts) / 3; batches >= proposals {
+		t.Errorf("\nnumber of batches \ngot= %v, \nwant less than = %v", batches, proposals)
+	}
+}
+
+func TestRequestCombiningCancel(t *testing.T) {
+	nodes := []*Node{}
+	for i := uint64(1); i <= 3; i++ {
+		n := NewNode(i, &InmemStore{})
+		n.AddTransport(&hungTransport{})
+		n.EnableRequestCombining()
+		nodes = append(nodes, n)
+	}
+	MingleNodes(nodes...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := nodes[0].ProposeContext(ctx, []byte("foo"), incrementFunc)
+	if err != context.DeadlineExceeded {
+		t.Errorf("\nnode.ProposeContext() \ngot= %v, \nwant = %v", err, context.DeadlineExceeded)
+	}
+
+	// the batch is abandoned once its only caller has given up.
+	for i := 0; ; i++ {
+		nodes[0].combinersMu.Lock()
+		pendingKeys := len(nodes[0].combiners)
+		nodes[0].combinersMu.Unlock()
+		if pendingKeys == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("\nthe batch is still running after its caller gave up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func BenchmarkProposeHotKey(b *testing.B) {
+	for _, combining := range []bool{false, true} {
+		name := "conflicting"
+		if combining {
+			name = "combining"
+		}
+		b.Run(name, func(b *testing.B) {
+			nodes := []*Node{}
+			for i := uint64(1); i <= 3; i++ {
+				n := NewNode(i, &InmemStore{})
+				n.AddTransport(&InmemTransport{Node: n})
+				n.AddRetryPolicy(RetryPolicy{MaxAttempts: 1000, InitialBackoff: 50 * time.Microsecond, MaxBackoff: 5 * time.Millisecond, Jitter: 1})
+				if combining {
+					n.EnableRequestCombining()
+				}
+				nodes = append(nodes, n)
+			}
+			MingleNodes(nodes...)
+			key := []byte("counter")
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := nodes[0].Propose(key, incrementFunc); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
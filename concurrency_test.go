@@ -0,0 +1,163 @@
+package kshaka
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// appendFunc is a ChangeFunction that appends ID to the comma separated list of IDs stored at a key.
+func appendFunc(ID int) ChangeFunction {
+	return func(current []byte) ([]byte, error) {
+		if len(current) == 0 {
+			return []byte(strconv.Itoa(ID)), nil
+		}
+		return []byte(fmt.Sprintf("%s,%d", current, ID)), nil
+	}
+}
+
+func newConcurrencyCluster(serialize bool) []*Node {
+	nodes := []*Node{}
+	for i := uint64(1); i <= 3; i++ {
+		n := NewNode(i, &InmemStore{})
+		n.AddTransport(&InmemTransport{Node: n})
+		n.AddRetryPolicy(RetryPolicy{MaxAttempts: 100, InitialBackoff: time.Millisecond, MaxBackoff: 50 * time.Millisecond, Jitter: 1})
+		if serialize {
+			n.SerializeKeyProposals()
+		}
+		nodes = append(nodes, n)
+	}
+	MingleNodes(nodes...)
+	return nodes
+}
+
+func TestConcurrentIncBallot(t *testing.T) {
+	n := NewNode(1, &InmemStore{})
+	const goroutines, ballotsPerGoroutine = 8, 500
+
+	var mu sync.Mutex
+	issued := map[Ballot]bool{}
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < ballotsPerGoroutine; i++ {
+				b, err := n.incBallot(nil)
+				if err != nil {
+					t.Errorf("\n n.incBallot() \nerr = %v", err)
+					return
+				}
+				mu.Lock()
+				issued[b] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	// conflicts fast-forward the Ballot while it is being incremented.
+	for i := 0; i < 100; i++ {
+		n.fastForward(nil, Ballot{Counter: uint64(i * 10), NodeID: 2})
+	}
+	wg.Wait()
+
+	if len(issued) != goroutines*ballotsPerGoroutine {
+		t.Errorf("\n n.incBallot() unique Ballots \ngot = %#+v, \nwanted = %#+v", len(issued), goroutines*ballotsPerGoroutine)
+	}
+}
+
+func TestConcurrentPropose(t *testing.T) {
+	const proposals = 10
+	tests := []struct {
+		name      string
+		serialize bool
+		proposers int
+	}{
+		{name: "one node", proposers: 1},
+		{name: "one node serialized", proposers: 1, serialize: true},
+		{name: "all nodes", proposers: 3},
+		{name: "all nodes serialized", proposers: 3, serialize: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := newConcurrencyCluster(tt.serialize)
+			key := []byte("foo")
+
+			var wg sync.WaitGroup
+			succeeded := make(chan int, proposals)
+			for ID := 0; ID < proposals; ID++ {
+				wg.Add(1)
+				go func(ID int) {
+					defer wg.Done()
+					proposer := nodes[ID%tt.proposers]
+					_, err := proposer.Propose(key, appendFunc(ID))
+					if err != nil {
+						t.Errorf("\nnode.Propose(%v) \nerr = %v", ID, err)
+						return
+					}
+					succeeded <- ID
+				}(ID)
+			}
+			wg.Wait()
+			close(succeeded)
+
+			val, err := nodes[0].Read(key)
+			if err != nil {
+				t.Fatalf("\nnode.Read() \nerr = %v", err)
+			}
+			appended := map[string]int{}
+			for _, ID := range bytes.Split(val, []byte(",")) {
+				appended[string(ID)]++
+			}
+			for ID := range succeeded {
+				// no acknowledged proposal is lost.
+				if appended[strconv.Itoa(ID)] == 0 {
+					t.Errorf("\nproposal:%v is missing from the state:%s", ID, val)
+				}
+			}
+			if tt.serialize && tt.proposers == 1 {
+				// the proposals never conflict, so none of them is applied twice.
+				for ID, count := range appended {
+					if count != 1 {
+						t.Errorf("\nproposal:%v was applied %v times, state:%s", ID, count, val)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrentProposeManyKeys(t *testing.T) {
+	nodes := newConcurrencyCluster(false)
+	const keys, proposalsPerKey = 10, 5
+
+	var wg sync.WaitGroup
+	for k := 0; k < keys; k++ {
+		for ID := 0; ID < proposalsPerKey; ID++ {
+			wg.Add(1)
+			go func(k, ID int) {
+				defer wg.Done()
+				key := []byte(fmt.Sprintf("key-%d", k))
+				if _, err := nodes[0].Propose(key, appendFunc(ID)); err != nil {
+					t.Errorf("\nnode.Propose(%s) \nerr = %v", key, err)
+				}
+			}(k, ID)
+		}
+	}
+	wg.Wait()
+
+	for k := 0; k < keys; k++ {
+		key := []byte(fmt.Sprintf("key-%d", k))
+		val, err := nodes[1].Read(key)
+		if err != nil {
+			t.Fatalf("\nnode.Read(%s) \nerr = %v", key, err)
+		}
+		for ID := 0; ID < proposalsPerKey; ID++ {
+			if !bytes.Contains([]byte(","+string(val)+","), []byte(fmt.Sprintf(",%d,", ID))) {
+				t.Errorf("\nproposal:%v is missing from key:%s, state:%s", ID, key, val)
+			}
+		}
+	}
+}
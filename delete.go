@@ -0,0 +1,296 @@
+package kshaka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Deleting a key follows the procedure in the CASPaxos paper.
+Node.Delete replaces the value of the key with a tombstone, an accepted empty value, through consensus like any other proposal.
+The tombstone still takes up the acceptor state record of the key on every acceptor,
+Node.CollectGarbage removes those records once it is safe to do so:
+  1. the tombstone is re-written, with the identity ChangeFunction, to all the acceptors instead of just a quorum.
+     Its Ballot, B, is remembered.
+  2. every proposer forgets the Ballots promised for its next proposal on the key, moves its Ballot counter past B
+     and bumps its age(the configuration epoch). Acceptors reject Ballots from an older epoch,
+     so a proposal that was started before this step can no longer write to the key.
+  3. every acceptor removes the record of the key, provided that it still holds the tombstone accepted with Ballot B.
+A key that is written to while it is being collected is left as it is.
+
+Like membership changes, garbage collection is sent over the Transport: listing and removing the tombstones go through GCTransport,
+and the age bump of step 2 through ConfigTransport.
+*/
+
+// tombstoneFunc is the ChangeFunction that deletes the current state.
+var tombstoneFunc ChangeFunction = func(current []byte) ([]byte, error) {
+	return nil, nil
+}
+
+// Delete removes the value stored at key, by writing a tombstone through consensus.
+// The acceptors keep the tombstone, and thus some state for the key, until Node.CollectGarbage removes it.
+func (n *Node) Delete(key []byte) error {
+	return n.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is like Delete but it is bound by ctx, in the same way that ProposeContext is.
+func (n *Node) DeleteContext(ctx context.Context, key []byte) error {
+	_, err := n.ProposeContext(ctx, key, tombstoneFunc)
+	return err
+}
+
+// CollectGarbage removes the tombstones that Node.Delete has left on the acceptors, and returns the number of keys that it removed.
+// Like membership changes; every node in the cluster is updated, and the store of every acceptor has to implement KeyIterator.
+// The Transport of every node has to implement GCTransport.
+// Stores that also implement DeleteStore have the state of the keys removed, the others have it overwritten with an empty value.
+// It is safe to run CollectGarbage while the cluster keeps serving proposals, and to run it again after it fails.
+func (n *Node) CollectGarbage(ctx context.Context) (int, error) {
+	acceptors := n.acceptAcceptors()
+	proposers := removeDuplicatesNodes(append([]*Node{n}, acceptors...))
+	transports := map[uint64]GCTransport{}
+	for _, p := range proposers {
+		t, ok := p.Trans.(GCTransport)
+		if !ok {
+			return 0, fmt.Errorf("the Transport of node:%v does not implement GCTransport", p.ID)
+		}
+		transports[p.ID] = t
+	}
+
+	keys := map[string]bool{}
+	for _, a := range acceptors {
+		tombstones, err := transports[a.ID].TransportTombstones(ctx)
+		if err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("unable to list the tombstones of acceptor:%v", a.ID))
+		}
+		for _, k := range tombstones {
+			keys[string(k)] = true
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	// 1. replicate the tombstones to all the acceptors.
+	age := Age{}
+	for k := range keys {
+		b, ok, err := n.replicateTombstone(ctx, acceptors, []byte(k))
+		if err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("unable to replicate the tombstone of key:%v", []byte(k)))
+		}
+		if !ok {
+			// the key is in use.
+			continue
+		}
+		age.Tombstones = append(age.Tombstones, Tombstone{Key: []byte(k), Ballot: b})
+	}
+	if len(age.Tombstones) == 0 {
+		return 0, nil
+	}
+
+	// 2. bump the age of every proposer.
+	err := n.sendEpoch(proposers, func(p *Node, epoch uint64) (uint64, error) {
+		age.Epoch = epoch
+		return transports[p.ID].TransportAge(ctx, age)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to bump the age of the proposers")
+	}
+
+	// 3. remove the tombstones from the acceptors.
+	collected := 0
+	for _, tombstone := range age.Tombstones {
+		removed := true
+		for _, a := range acceptors {
+			ok, err := transports[a.ID].TransportRemoveTombstone(ctx, tombstone.Ballot, tombstone.Key)
+			if err != nil {
+				return collected, errors.Wrap(err, fmt.Sprintf("unable to remove the tombstone of key:%v from acceptor:%v", tombstone.Key, a.ID))
+			}
+			removed = removed && ok
+		}
+		if removed {
+			collected++
+		}
+	}
+	return collected, nil
+}
+
+// Age is the age bump that Node.CollectGarbage sends to every proposer, once the tombstones are on all the acceptors.
+type Age struct {
+	// Epoch is the configuration epoch that the proposers move to; acceptors reject the Ballots of older epochs.
+	Epoch uint64
+	// Tombstones are the keys that are being collected, and the Ballots that their tombstones were accepted with.
+	Tombstones []Tombstone
+}
+
+// Tombstone is a key whose tombstone was accepted with Ballot.
+type Tombstone struct {
+	Key    []byte
+	Ballot Ballot
+}
+
+// BumpAge handles an age bump sent by the node that runs a garbage collection.
+// The node forgets the Ballots it prepared for its next proposal on the keys of a, moves its Ballot counters past theirs
+// and runs a.Epoch, unless it already runs a newer epoch; either way it returns the epoch that it runs.
+func (n *Node) BumpAge(a Age) (uint64, error) {
+	for _, t := range a.Tombstones {
+		n.takePrepared(t.Key)
+		n.fastForward(t.Key, t.Ballot)
+	}
+	err := n.setEpoch(a.Epoch)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("unable to bump the age of node:%v", n.ID))
+	}
+	return n.currentEpoch()
+}
+
+// setEpoch bumps the epoch of the configuration that the node runs, unless it already runs a newer one.
+func (n *Node) setEpoch(epoch uint64) error {
+	n.configMu.Lock()
+	defer n.configMu.Unlock()
+	return n.storeEpoch(epoch)
+}
+
+// Tombstones handles a request for the keys for which the acceptor has accepted a tombstone, as sent by the node that runs a garbage collection.
+func (n *Node) Tombstones() ([][]byte, error) {
+	return n.tombstones()
+}
+
+// RemoveTombstone handles a request to remove the tombstone of key that was accepted with Ballot b, as sent by the node that runs a garbage collection.
+// It reports whether the key no longer has any state.
+func (n *Node) RemoveTombstone(b Ballot, key []byte) (bool, error) {
+	return n.removeTombstone(b, key)
+}
+
+// replicateTombstone writes the tombstone of key to all the acceptors and returns the Ballot that it was accepted with.
+// It reports false if the key no longer holds a tombstone, or if another proposer got in the way;
+// failing to reach an acceptor is an error.
+func (n *Node) replicateTombstone(ctx context.Context, acceptors []*Node, key []byte) (Ballot, bool, error) {
+	policy := n.retryPolicy
+	if policy.MaxAttempts < catchUpRetryPolicy.MaxAttempts {
+		policy = catchUpRetryPolicy
+	}
+	var ballot Ballot
+	currentState, err := n.withRetries(ctx, policy, "prepare", func() ([]byte, error) {
+		b, currentState, err := n.sendPrepare(ctx, key)
+		ballot = b
+		return currentState, err
+	})
+	if quorumErr, ok := errors.Cause(err).(*ErrQuorumNotReached); ok && quorumErr.conflicted() {
+		return Ballot{}, false, nil
+	}
+	if err != nil {
+		return Ballot{}, false, err
+	}
+	if currentState != nil {
+		return Ballot{}, false, nil
+	}
+
+	acceptResultChan := make(chan error, len(acceptors))
+	for _, a := range acceptors {
+		go func(a *Node) {
+			_, err := a.Trans.TransportAccept(ctx, ballot, key, nil)
+			acceptResultChan <- err
+		}(a)
+	}
+	replicated := true
+	for i := 0; i < cap(acceptResultChan); i++ {
+		var err error
+		select {
+		case err = <-acceptResultChan:
+		case <-ctx.Done():
+			return Ballot{}, false, ctx.Err()
+		}
+		var conflict *ErrConflict
+		if errors.As(err, &conflict) {
+			replicated = false
+		} else if err != nil {
+			// the tombstone has to be on every acceptor, so an acceptor that cannot be reached stops the collection.
+			return Ballot{}, false, err
+		}
+	}
+	return ballot, replicated, nil
+}
+
+// tombstones returns the keys for which the acceptor has accepted a tombstone.
+func (n *Node) tombstones() ([][]byte, error) {
+	keys, err := n.keys()
+	if err != nil {
+		return nil, err
+	}
+	tombstones := [][]byte{}
+	for _, k := range keys {
+		acceptorState, err := n.getAcceptorState(k)
+		if err != nil {
+			return nil, err
+		}
+		if acceptorState.State == nil && !acceptorState.AcceptedBallot.Equal(Ballot{}) {
+			tombstones = append(tombstones, k)
+		}
+	}
+	return tombstones, nil
+}
+
+// removeTombstone removes the state of key from the acceptor, if it is the tombstone accepted with Ballot b.
+// It reports whether the key no longer has any state.
+func (n *Node) removeTombstone(b Ballot, key []byte) (bool, error) {
+	unlock := n.keyLocks.lock(key)
+	defer unlock()
+
+	txn, err := n.beginData()
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback() // nolint: errcheck
+
+	acceptorState, _, err := n.readAcceptorState(txn, key)
+	if err != nil {
+		return false, err
+	}
+	if acceptorState.State == nil && acceptorState.AcceptedBallot.Equal(Ballot{}) && acceptorState.PromisedBallot.Equal(Ballot{}) {
+		// already removed.
+		return true, nil
+	}
+	if acceptorState.State != nil || !acceptorState.AcceptedBallot.Equal(b) {
+		// the key has been written to since.
+		return false, nil
+	}
+	if b.Less(acceptorState.PromisedBallot) {
+		// a proposer has prepared the key since; removing its promise would let a lower Ballot through.
+		return false, nil
+	}
+
+	err = txn.Delete(key)
+	if err != nil {
+		return false, err
+	}
+	err = txn.Commit()
+	if err != nil {
+		return false, err
+	}
+	err = n.removeLegacyAcceptorState(key)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// removeKey removes k from store.
+// If store does not implement DeleteStore, k is overwritten with an empty value; provided it is not empty already.
+func removeKey(store StableStore, k []byte) error {
+	if s, ok := store.(DeleteStore); ok {
+		return s.Delete(k)
+	}
+	val, err := store.Get(k)
+	if errors.Is(err, ErrNotFound) {
+		val, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(val) == 0 {
+		return nil
+	}
+	return store.Set(k, []byte{})
+}
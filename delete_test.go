@@ -0,0 +1,207 @@
+package kshaka
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+	// overwriteStore is a store that does not implement DeleteStore.
+	type overwriteStore struct {
+		StableStore
+		KeyIterator
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		newStore func() StableStore
+	}{
+		{name: "DeleteStore", newStore: func() StableStore { return &InmemStore{} }},
+		{name: "StableStore", newStore: func() StableStore {
+			s := &InmemStore{}
+			return overwriteStore{s, s}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := []*InmemStore{}
+			nodes := []*Node{}
+			for i := uint64(1); i <= 3; i++ {
+				store := tt.newStore()
+				n := NewNode(i, store)
+				n.AddTransport(&InmemTransport{Node: n})
+				n.AddRetryPolicy(RetryPolicy{MaxAttempts: 5})
+				nodes = append(nodes, n)
+				if s, ok := store.(*InmemStore); ok {
+					stores = append(stores, s)
+				} else {
+					stores = append(stores, store.(overwriteStore).StableStore.(*InmemStore))
+				}
+			}
+			MingleNodes(nodes...)
+
+			for i := 0; i < 3; i++ {
+				key := []byte(fmt.Sprintf("key-%d", i))
+				if _, err := nodes[0].Propose(key, setFunc([]byte("val"))); err != nil {
+					t.Fatalf("\nnode.Propose() \nerr = %v", err)
+				}
+			}
+			deleted := []byte("key-0")
+			if err := nodes[1].Delete(deleted); err != nil {
+				t.Fatalf("\nnode.Delete() \nerr = %v", err)
+			}
+			val, err := nodes[2].Read(deleted)
+			if err != nil {
+				t.Fatalf("\nnode.Read() \nerr = %v", err)
+			}
+			if val != nil {
+				t.Errorf("\nnode.Read() \ngot= %s, \nwant = %v", val, nil)
+			}
+			// a proposal that started before the garbage collection.
+			outdatedBallot := nodes[0].Ballot
+			outdatedBallot.Counter = outdatedBallot.Counter + 100
+
+			collected, err := nodes[2].CollectGarbage(ctx)
+			if err != nil {
+				t.Fatalf("\nnode.CollectGarbage() \nerr = %v", err)
+			}
+			if collected != 1 {
+				t.Errorf("\nnode.CollectGarbage() \ngot= %v, \nwant = %v", collected, 1)
+			}
+			for i, s := range stores {
+				if got := storedRecords(s); got != 2 {
+					t.Errorf("\nacceptor:%v number of records \ngot= %v, \nwant = %v", i+1, got, 2)
+				}
+			}
+			// nothing left to collect.
+			collected, err = nodes[2].CollectGarbage(ctx)
+			if err != nil {
+				t.Fatalf("\nnode.CollectGarbage() \nerr = %v", err)
+			}
+			if collected != 0 {
+				t.Errorf("\nnode.CollectGarbage() \ngot= %v, \nwant = %v", collected, 0)
+			}
+
+			if _, err := nodes[0].Accept(outdatedBallot, deleted, []byte("stale")); err == nil {
+				t.Errorf("\nnode.Accept() \nwanted an error since the Ballot predates the garbage collection")
+			}
+			for _, n := range nodes {
+				if _, err := n.Propose(deleted, setFunc([]byte("new-val"))); err != nil {
+					t.Fatalf("\nnode.Propose() \nerr = %v", err)
+				}
+			}
+			val, err = nodes[0].Read(deleted)
+			if err != nil {
+				t.Fatalf("\nnode.Read() \nerr = %v", err)
+			}
+			if !bytes.Equal(val, []byte("new-val")) {
+				t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", val, "new-val")
+			}
+		})
+	}
+}
+
+// plainTransport only implements the Transport interface, like a Transport written before garbage collection was sent over it.
+type plainTransport struct {
+	Transport
+}
+
+func TestCollectGarbageUnsupported(t *testing.T) {
+	nodes := []*Node{}
+	for i := uint64(1); i <= 3; i++ {
+		n := NewNode(i, &InmemStore{})
+		if i == 3 {
+			n.AddTransport(plainTransport{&InmemTransport{Node: n}})
+		} else {
+			n.AddTransport(&InmemTransport{Node: n})
+		}
+		nodes = append(nodes, n)
+	}
+	MingleNodes(nodes...)
+
+	key := []byte("foo")
+	if _, err := nodes[0].Propose(key, func(current []byte) ([]byte, error) { return []byte("bar"), nil }); err != nil {
+		t.Fatalf("\nnode.Propose() \nerr = %v", err)
+	}
+	if err := nodes[0].Delete(key); err != nil {
+		t.Fatalf("\nnode.Delete() \nerr = %v", err)
+	}
+	collected, err := nodes[0].CollectGarbage(context.Background())
+	if err == nil {
+		t.Errorf("\nnode.CollectGarbage() \nwanted an error since the Transport of node:3 does not implement GCTransport")
+	}
+	if collected != 0 {
+		t.Errorf("\nnode.CollectGarbage() \ngot= %v, \nwant = %v", collected, 0)
+	}
+}
+
+func TestRemoveTombstone(t *testing.T) {
+	key := []byte("foo")
+	tombstone := Ballot{Counter: 5, NodeID: 1}
+
+	tests := []struct {
+		name        string
+		setup       func(n *Node) error
+		wantRemoved bool
+	}{
+		{name: "tombstone", setup: func(n *Node) error {
+			_, err := n.Accept(tombstone, key, nil)
+			return err
+		}, wantRemoved: true},
+		{name: "written to since", setup: func(n *Node) error {
+			_, err := n.Accept(Ballot{Counter: 6, NodeID: 2}, key, []byte("bar"))
+			return err
+		}, wantRemoved: false},
+		{name: "prepared since", setup: func(n *Node) error {
+			_, err := n.Accept(tombstone, key, nil)
+			if err != nil {
+				return err
+			}
+			_, err = n.Prepare(Ballot{Counter: 6, NodeID: 2}, key)
+			return err
+		}, wantRemoved: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &InmemStore{}
+			n := NewNode(1, store)
+			if err := tt.setup(n); err != nil {
+				t.Fatal(err)
+			}
+			removed, err := n.removeTombstone(tombstone, key)
+			if err != nil {
+				t.Fatalf("\nnode.removeTombstone() \nerr = %v", err)
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("\nnode.removeTombstone() \ngot= %v, \nwant = %v", removed, tt.wantRemoved)
+			}
+			wantRecords := 1
+			if tt.wantRemoved {
+				wantRecords = 0
+			}
+			if got := storedRecords(store); got != wantRecords {
+				t.Errorf("\nnumber of records \ngot= %v, \nwant = %v", got, wantRecords)
+			}
+		})
+	}
+}
+
+// storedRecords returns the number of non empty acceptor state records in s.
+func storedRecords(s *InmemStore) int {
+	records := 0
+	_ = s.IteratePrefix(namespacePrefix(dataNamespace), func(k []byte, val []byte) error {
+		if len(val) > 0 {
+			records++
+		}
+		return nil
+	})
+	return records
+}
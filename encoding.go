@@ -0,0 +1,150 @@
+package kshaka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+)
+
+/*
+Ballots and acceptor states are persisted in a fixed-width binary encoding, with all integers in big-endian order:
+
+	Ballot:        magic(4 bytes) | version(1 byte) | Counter(8) | NodeID(8) | Epoch(8)
+	AcceptorState: magic(4 bytes) | version(1 byte) | PromisedBallot(24) | AcceptedBallot(24) | State(the rest)
+
+The Ballots inside an AcceptorState are not prefixed with the magic and version. An empty State is decoded as nil.
+Older versions of kshaka persisted them with encoding/gob; values that do not start with the magic are decoded as gob.
+*/
+
+// encodingMagic starts every binary encoded value. A gob stream can never start with it:
+// gob streams start with a message length, and gob only starts an unsigned integer with 0xff if a single byte of at least 0x80 follows.
+var encodingMagic = []byte{0xff, 0x00, 'k', 's'}
+
+// encodingVersion is the version of the binary encoding that is written.
+const encodingVersion = 1
+
+const (
+	encodingHeaderLen = 5  // len(encodingMagic) + the version.
+	ballotLen         = 24 // Counter, NodeID and Epoch.
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (b Ballot) MarshalBinary() ([]byte, error) {
+	data := make([]byte, encodingHeaderLen+ballotLen)
+	putHeader(data)
+	putBallot(data[encodingHeaderLen:], b)
+	return data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (b *Ballot) UnmarshalBinary(data []byte) error {
+	if err := checkHeader(data); err != nil {
+		return err
+	}
+	if len(data) != encodingHeaderLen+ballotLen {
+		return fmt.Errorf("binary encoded Ballot should be %v bytes, not %v", encodingHeaderLen+ballotLen, len(data))
+	}
+	*b = getBallot(data[encodingHeaderLen:])
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (a AcceptorState) MarshalBinary() ([]byte, error) {
+	data := make([]byte, encodingHeaderLen+2*ballotLen+len(a.State))
+	putHeader(data)
+	putBallot(data[encodingHeaderLen:], a.PromisedBallot)
+	putBallot(data[encodingHeaderLen+ballotLen:], a.AcceptedBallot)
+	copy(data[encodingHeaderLen+2*ballotLen:], a.State)
+	return data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+// State is copied out of data, so data can be reused once UnmarshalBinary returns.
+func (a *AcceptorState) UnmarshalBinary(data []byte) error {
+	if err := checkHeader(data); err != nil {
+		return err
+	}
+	if len(data) < encodingHeaderLen+2*ballotLen {
+		return fmt.Errorf("binary encoded AcceptorState should be at least %v bytes, not %v", encodingHeaderLen+2*ballotLen, len(data))
+	}
+	a.PromisedBallot = getBallot(data[encodingHeaderLen:])
+	a.AcceptedBallot = getBallot(data[encodingHeaderLen+ballotLen:])
+	a.State = nil
+	if state := data[encodingHeaderLen+2*ballotLen:]; len(state) > 0 {
+		a.State = append([]byte{}, state...)
+	}
+	return nil
+}
+
+func putHeader(data []byte) {
+	copy(data, encodingMagic)
+	data[len(encodingMagic)] = encodingVersion
+}
+
+func checkHeader(data []byte) error {
+	if !isBinaryEncoded(data) {
+		return fmt.Errorf("data is not binary encoded by kshaka")
+	}
+	if version := data[len(encodingMagic)]; version != encodingVersion {
+		return fmt.Errorf("binary encoding version:%v is not supported", version)
+	}
+	return nil
+}
+
+// isBinaryEncoded reports whether data starts with the magic of the binary encoding, rather than being gob encoded.
+func isBinaryEncoded(data []byte) bool {
+	return len(data) >= encodingHeaderLen && bytes.HasPrefix(data, encodingMagic)
+}
+
+func putBallot(data []byte, b Ballot) {
+	binary.BigEndian.PutUint64(data, b.Counter)
+	binary.BigEndian.PutUint64(data[8:], b.NodeID)
+	binary.BigEndian.PutUint64(data[16:], b.Epoch)
+}
+
+func getBallot(data []byte) Ballot {
+	return Ballot{
+		Counter: binary.BigEndian.Uint64(data),
+		NodeID:  binary.BigEndian.Uint64(data[8:]),
+		Epoch:   binary.BigEndian.Uint64(data[16:]),
+	}
+}
+
+// gobBallot and gobAcceptorState have the fields that Ballot and AcceptorState were gob encoded with.
+// Ballot and AcceptorState can not be used to decode those, since gob would use their UnmarshalBinary methods.
+type gobBallot struct {
+	Counter uint64
+	NodeID  uint64
+	Epoch   uint64
+}
+
+type gobAcceptorState struct {
+	PromisedBallot gobBallot
+	AcceptedBallot gobBallot
+	State          []byte
+}
+
+// decodeBallot decodes a Ballot that is either binary or gob encoded.
+func decodeBallot(data []byte) (Ballot, error) {
+	var b Ballot
+	if isBinaryEncoded(data) {
+		err := b.UnmarshalBinary(data)
+		return b, err
+	}
+	var gb gobBallot
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&gb)
+	return Ballot(gb), err
+}
+
+// decodeAcceptorState decodes an AcceptorState that is either binary or gob encoded.
+func decodeAcceptorState(data []byte) (AcceptorState, error) {
+	var acceptorState AcceptorState
+	if isBinaryEncoded(data) {
+		err := acceptorState.UnmarshalBinary(data)
+		return acceptorState, err
+	}
+	var ga gobAcceptorState
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&ga)
+	return AcceptorState{PromisedBallot: Ballot(ga.PromisedBallot), AcceptedBallot: Ballot(ga.AcceptedBallot), State: ga.State}, err
+}
@@ -0,0 +1,157 @@
+package kshaka
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+)
+
+func gobEncode(t testing.TB, v interface{}) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBallotBinaryEncoding(t *testing.T) {
+	b := Ballot{Counter: 1 << 40, NodeID: 7, Epoch: 3}
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != encodingHeaderLen+ballotLen {
+		t.Errorf("\nBallot.MarshalBinary() \ngot = %v bytes, \nwanted = %v bytes", len(data), encodingHeaderLen+ballotLen)
+	}
+	var got Ballot
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if got != b {
+		t.Errorf("\nBallot.UnmarshalBinary() \ngot = %#+v, \nwanted = %#+v", got, b)
+	}
+
+	// older versions of kshaka gob encoded Ballots.
+	got, err = decodeBallot(gobEncode(t, gobBallot(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != b {
+		t.Errorf("\ndecodeBallot(gob) \ngot = %#+v, \nwanted = %#+v", got, b)
+	}
+
+	bad := append([]byte{}, data...)
+	bad[len(encodingMagic)] = encodingVersion + 1
+	if err := got.UnmarshalBinary(bad); err == nil {
+		t.Errorf("\nBallot.UnmarshalBinary() \nwanted an error for an unsupported version")
+	}
+	if err := got.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Errorf("\nBallot.UnmarshalBinary() \nwanted an error for truncated data")
+	}
+}
+
+func TestAcceptorStateBinaryEncoding(t *testing.T) {
+	tests := []struct {
+		name  string
+		state AcceptorState
+	}{
+		{name: "empty", state: AcceptorState{}},
+		{name: "promised", state: AcceptorState{PromisedBallot: Ballot{Counter: 2, NodeID: 1}}},
+		{name: "tombstone", state: AcceptorState{AcceptedBallot: Ballot{Counter: 9, NodeID: 3, Epoch: 1}}},
+		{name: "state", state: AcceptorState{PromisedBallot: Ballot{Counter: 10, NodeID: 2}, AcceptedBallot: Ballot{Counter: 9, NodeID: 3}, State: []byte("bar")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.state.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got AcceptorState
+			if err := got.UnmarshalBinary(data); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.state) {
+				t.Errorf("\nAcceptorState.UnmarshalBinary() \ngot = %#+v, \nwanted = %#+v", got, tt.state)
+			}
+
+			// older versions of kshaka gob encoded acceptor states.
+			old := gobAcceptorState{PromisedBallot: gobBallot(tt.state.PromisedBallot), AcceptedBallot: gobBallot(tt.state.AcceptedBallot), State: tt.state.State}
+			got, err = decodeAcceptorState(gobEncode(t, old))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.state) {
+				t.Errorf("\ndecodeAcceptorState(gob) \ngot = %#+v, \nwanted = %#+v", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestAcceptorStateGobMigration(t *testing.T) {
+	store := &InmemStore{}
+	n := NewNode(1, store)
+	key := []byte("foo")
+	old := gobAcceptorState{AcceptedBallot: gobBallot{Counter: 4, NodeID: 2}, State: []byte("bar")}
+	if err := n.dataStore().Set(key, gobEncode(t, old)); err != nil {
+		t.Fatal(err)
+	}
+
+	acceptorState, err := n.Accept(Ballot{Counter: 5, NodeID: 1}, key, []byte("baz"))
+	if err != nil {
+		t.Fatalf("\nn.Accept() \nerr = %v", err)
+	}
+	record, err := n.dataStore().Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isBinaryEncoded(record) {
+		t.Errorf("\nthe record of key:%s is not binary encoded", key)
+	}
+	got, err := n.getAcceptorState(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, acceptorState) {
+		t.Errorf("\nn.getAcceptorState() \ngot = %#+v, \nwanted = %#+v", got, acceptorState)
+	}
+}
+
+func BenchmarkAcceptorStateEncoding(b *testing.B) {
+	acceptorState := AcceptorState{PromisedBallot: Ballot{Counter: 10, NodeID: 2}, AcceptedBallot: Ballot{Counter: 9, NodeID: 3}, State: []byte("bar")}
+	old := gobAcceptorState{PromisedBallot: gobBallot(acceptorState.PromisedBallot), AcceptedBallot: gobBallot(acceptorState.AcceptedBallot), State: acceptorState.State}
+
+	b.Run("gob", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data := gobEncode(b, old)
+			if _, err := decodeAcceptorState(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("binary", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, err := acceptorState.MarshalBinary()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := decodeAcceptorState(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAccept(b *testing.B) {
+	n := NewNode(1, &InmemStore{})
+	key, state := []byte("foo"), []byte("bar")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := n.Accept(Ballot{Counter: uint64(i + 1), NodeID: 1}, key, state); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
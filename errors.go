@@ -0,0 +1,278 @@
+package kshaka
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrConflict is returned by an acceptor that has already seen a Ballot greater than the one submitted to it,
+// or that runs a newer configuration epoch than the proposer did when it generated that Ballot.
+// State is the acceptor state that the acceptor has for the key; proposers use it to fast-forward their Ballots.
+type ErrConflict struct {
+	AcceptorID uint64
+	Submitted  Ballot
+	// Ballot is the greatest Ballot that the acceptor has seen, which Submitted conflicts with.
+	Ballot Ballot
+	// Epoch is the configuration epoch of the acceptor.
+	Epoch uint64
+	State AcceptorState
+}
+
+func (e *ErrConflict) Error() string {
+	if e.Submitted.Epoch < e.Epoch {
+		return fmt.Sprintf("submitted Ballot:%v is from configuration epoch:%v which is older than epoch:%v of acceptor:%v", e.Submitted, e.Submitted.Epoch, e.Epoch, e.AcceptorID)
+	}
+	return fmt.Sprintf("submitted Ballot:%v is less than Ballot:%v of acceptor:%v", e.Submitted, e.Ballot, e.AcceptorID)
+}
+
+// Is reports whether target is an *ErrConflict, so that errors.Is(err, &ErrConflict{}) matches any conflict.
+func (e *ErrConflict) Is(target error) bool {
+	_, ok := target.(*ErrConflict)
+	return ok
+}
+
+// ErrQuorumNotReached is returned by a proposer that did not get a quorum of confirmations in a phase.
+// Causes holds the error that each acceptor, keyed by its ID, replied with; acceptors that had not replied yet are not in it.
+// A cause is either an *ErrConflict or the error that the Transport failed to deliver the message with.
+type ErrQuorumNotReached struct {
+	Phase         Phase
+	Confirmations int
+	Acceptors     int
+	// Ballot is the greatest Ballot that the acceptors conflicted with, if any.
+	Ballot Ballot
+	Causes map[uint64]error
+}
+
+func (e *ErrQuorumNotReached) Error() string {
+	IDs := make([]uint64, 0, len(e.Causes))
+	for ID := range e.Causes {
+		IDs = append(IDs, ID)
+	}
+	sort.Slice(IDs, func(i, j int) bool { return IDs[i] < IDs[j] })
+	causes := make([]string, 0, len(IDs))
+	for _, ID := range IDs {
+		causes = append(causes, fmt.Sprintf("acceptor:%v: %v", ID, e.Causes[ID]))
+	}
+	return fmt.Sprintf("%v confirmations:%v out of %v acceptors are not a quorum; [%v]", e.Phase, e.Confirmations, e.Acceptors, strings.Join(causes, ", "))
+}
+
+// conflicted reports whether any of the acceptors replied with a conflict, rather than failing to reply.
+func (e *ErrQuorumNotReached) conflicted() bool {
+	for _, cause := range e.Causes {
+		var conflict *ErrConflict
+		if errors.As(cause, &conflict) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is reports whether target is an *ErrQuorumNotReached.
+func (e *ErrQuorumNotReached) Is(target error) bool {
+	_, ok := target.(*ErrQuorumNotReached)
+	return ok
+}
+
+// ErrTooFewAcceptors is returned by a proposer that knows of fewer acceptors than CASPaxos needs.
+type ErrTooFewAcceptors struct {
+	Acceptors int
+	Minimum   int
+}
+
+func (e *ErrTooFewAcceptors) Error() string {
+	return fmt.Sprintf("number of acceptors:%v is less than required minimum of:%v", e.Acceptors, e.Minimum)
+}
+
+// Is reports whether target is an *ErrTooFewAcceptors.
+func (e *ErrTooFewAcceptors) Is(target error) bool {
+	_, ok := target.(*ErrTooFewAcceptors)
+	return ok
+}
+
+// ErrReservedKey is returned when a key that kshaka uses to store its own state is proposed.
+type ErrReservedKey struct {
+	Key []byte
+}
+
+func (e *ErrReservedKey) Error() string {
+	return fmt.Sprintf("the key:%v is reserved for storing kshaka internal state. chose another key", e.Key)
+}
+
+// Is reports whether target is an *ErrReservedKey.
+func (e *ErrReservedKey) Is(target error) bool {
+	_, ok := target.(*ErrReservedKey)
+	return ok
+}
+
+// ErrChangeFunction is returned when a ChangeFunction returns an error, Err.
+type ErrChangeFunction struct {
+	Key []byte
+	Err error
+}
+
+func (e *ErrChangeFunction) Error() string {
+	return fmt.Sprintf("unable to apply the ChangeFunction to value at key:%v: %v", e.Key, e.Err)
+}
+
+// Unwrap returns the error that the ChangeFunction returned.
+func (e *ErrChangeFunction) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the error that the ChangeFunction returned, for github.com/pkg/errors.Cause
+func (e *ErrChangeFunction) Cause() error {
+	return e.Err
+}
+
+// Is reports whether target is an *ErrChangeFunction.
+func (e *ErrChangeFunction) Is(target error) bool {
+	_, ok := target.(*ErrChangeFunction)
+	return ok
+}
+
+// errorJSON is the serialized form of an error; see MarshalError
+type errorJSON struct {
+	Type    string
+	Message string
+
+	AcceptorID    uint64               `json:",omitempty"`
+	Submitted     *Ballot              `json:",omitempty"`
+	Ballot        *Ballot              `json:",omitempty"`
+	Epoch         uint64               `json:",omitempty"`
+	State         *AcceptorState       `json:",omitempty"`
+	Phase         Phase                `json:",omitempty"`
+	Confirmations int                  `json:",omitempty"`
+	Acceptors     int                  `json:",omitempty"`
+	Minimum       int                  `json:",omitempty"`
+	Causes        map[uint64]errorJSON `json:",omitempty"`
+	Key           []byte               `json:",omitempty"`
+	Err           *errorJSON           `json:",omitempty"`
+}
+
+const (
+	errorTypeConflict         = "conflict"
+	errorTypeQuorumNotReached = "quorumNotReached"
+	errorTypeTooFewAcceptors  = "tooFewAcceptors"
+	errorTypeReservedKey      = "reservedKey"
+	errorTypeChangeFunction   = "changeFunction"
+	errorTypeUnknown          = "error"
+)
+
+func toErrorJSON(err error) errorJSON {
+	e := errorJSON{Type: errorTypeUnknown, Message: err.Error()}
+	switch cause := errors.Cause(err).(type) {
+	case *ErrConflict:
+		e.Type = errorTypeConflict
+		e.AcceptorID, e.Submitted, e.Ballot, e.Epoch, e.State = cause.AcceptorID, &cause.Submitted, &cause.Ballot, cause.Epoch, &cause.State
+	case *ErrQuorumNotReached:
+		e.Type = errorTypeQuorumNotReached
+		e.Phase, e.Confirmations, e.Acceptors, e.Ballot = cause.Phase, cause.Confirmations, cause.Acceptors, &cause.Ballot
+		if len(cause.Causes) > 0 {
+			e.Causes = map[uint64]errorJSON{}
+			for ID, c := range cause.Causes {
+				e.Causes[ID] = toErrorJSON(c)
+			}
+		}
+	case *ErrTooFewAcceptors:
+		e.Type = errorTypeTooFewAcceptors
+		e.Acceptors, e.Minimum = cause.Acceptors, cause.Minimum
+	case *ErrReservedKey:
+		e.Type = errorTypeReservedKey
+		e.Key = cause.Key
+	}
+	// ErrChangeFunction is itself a cause, so it is looked for separately.
+	var changeFuncErr *ErrChangeFunction
+	if errors.As(err, &changeFuncErr) {
+		e.Type = errorTypeChangeFunction
+		e.Key = changeFuncErr.Key
+		if changeFuncErr.Err != nil {
+			inner := errorJSON{Type: errorTypeUnknown, Message: changeFuncErr.Err.Error()}
+			e.Err = &inner
+		}
+	}
+	return e
+}
+
+func (e errorJSON) toError() error {
+	var err error
+	switch e.Type {
+	case errorTypeConflict:
+		conflict := &ErrConflict{AcceptorID: e.AcceptorID, Epoch: e.Epoch}
+		if e.Submitted != nil {
+			conflict.Submitted = *e.Submitted
+		}
+		if e.Ballot != nil {
+			conflict.Ballot = *e.Ballot
+		}
+		if e.State != nil {
+			conflict.State = *e.State
+		}
+		err = conflict
+	case errorTypeQuorumNotReached:
+		quorumErr := &ErrQuorumNotReached{Phase: e.Phase, Confirmations: e.Confirmations, Acceptors: e.Acceptors}
+		if e.Ballot != nil {
+			quorumErr.Ballot = *e.Ballot
+		}
+		if len(e.Causes) > 0 {
+			quorumErr.Causes = map[uint64]error{}
+			for ID, c := range e.Causes {
+				quorumErr.Causes[ID] = c.toError()
+			}
+		}
+		err = quorumErr
+	case errorTypeTooFewAcceptors:
+		err = &ErrTooFewAcceptors{Acceptors: e.Acceptors, Minimum: e.Minimum}
+	case errorTypeReservedKey:
+		err = &ErrReservedKey{Key: e.Key}
+	case errorTypeChangeFunction:
+		changeFuncErr := &ErrChangeFunction{Key: e.Key}
+		if e.Err != nil {
+			changeFuncErr.Err = fmt.Errorf("%s", e.Err.Message)
+		}
+		err = changeFuncErr
+	default:
+		return fmt.Errorf("%s", e.Message)
+	}
+	if err.Error() == e.Message {
+		return err
+	}
+	// keep the context that the error had been wrapped with.
+	return &remoteError{message: e.Message, cause: err}
+}
+
+// remoteError is an error that was deserialized with UnmarshalError, whose message has more context than its cause.
+type remoteError struct {
+	message string
+	cause   error
+}
+
+func (e *remoteError) Error() string { return e.message }
+
+// Cause returns the underlying kshaka error, for github.com/pkg/errors.Cause
+func (e *remoteError) Cause() error { return e.cause }
+
+// Unwrap returns the underlying kshaka error.
+func (e *remoteError) Unwrap() error { return e.cause }
+
+// MarshalError serializes err, so that a Transport can send it to another node.
+// The kshaka errors; ErrConflict, ErrQuorumNotReached, ErrTooFewAcceptors, ErrReservedKey and ErrChangeFunction, keep their type and fields,
+// even if err wraps them. Any other error is only kept as its message.
+func MarshalError(err error) ([]byte, error) {
+	return json.Marshal(toErrorJSON(err))
+}
+
+// UnmarshalError deserializes an error that was serialized with MarshalError.
+// The kshaka errors can be matched with errors.Is and errors.As, and the message of the error is preserved.
+// If data is not a serialized error, the returned error says so.
+func UnmarshalError(data []byte) error {
+	var e errorJSON
+	err := json.Unmarshal(data, &e)
+	if err != nil || e.Type == "" {
+		return fmt.Errorf("unable to unmarshal error:%q", data)
+	}
+	return e.toError()
+}
@@ -0,0 +1,238 @@
+package kshaka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestErrorTypes(t *testing.T) {
+	newCluster := func(size uint64) []*Node {
+		nodes := []*Node{}
+		for i := uint64(1); i <= size; i++ {
+			n := NewNode(i, &InmemStore{})
+			n.AddTransport(&InmemTransport{Node: n})
+			nodes = append(nodes, n)
+		}
+		MingleNodes(nodes...)
+		return nodes
+	}
+
+	t.Run("too few acceptors", func(t *testing.T) {
+		nodes := newCluster(2)
+		_, err := nodes[0].Propose([]byte("foo"), identityFunc)
+		var tooFew *ErrTooFewAcceptors
+		if !errors.As(err, &tooFew) {
+			t.Fatalf("\nnode.Propose() \ngot= %v, \nwant = %T", err, tooFew)
+		}
+		if tooFew.Acceptors != 2 || tooFew.Minimum != minimumNoAcceptors {
+			t.Errorf("\nErrTooFewAcceptors \ngot= %#+v, \nwant = %#+v", tooFew, &ErrTooFewAcceptors{Acceptors: 2, Minimum: minimumNoAcceptors})
+		}
+	})
+
+	t.Run("reserved keys", func(t *testing.T) {
+		nodes := newCluster(3)
+		for _, key := range [][]byte{acceptorStateKey([]byte("foo")), acceptedBallotKey([]byte("foo")), promisedBallotKey(nil), ballotCounterKey} {
+			_, err := nodes[0].Propose(key, identityFunc)
+			if !errors.Is(err, &ErrReservedKey{}) {
+				t.Errorf("\nnode.Propose(%s) \ngot= %v, \nwant = %T", key, err, &ErrReservedKey{})
+			}
+		}
+	})
+
+	t.Run("change function", func(t *testing.T) {
+		nodes := newCluster(3)
+		userErr := errors.New("bad change")
+		_, err := nodes[0].Propose([]byte("foo"), func(current []byte) ([]byte, error) { return nil, userErr })
+		if !errors.Is(err, userErr) {
+			t.Errorf("\nnode.Propose() \ngot= %v, \nwant = %v", err, userErr)
+		}
+		var changeFuncErr *ErrChangeFunction
+		if !errors.As(err, &changeFuncErr) || string(changeFuncErr.Key) != "foo" {
+			t.Errorf("\nnode.Propose() \ngot= %#+v, \nwant = %T", err, changeFuncErr)
+		}
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		nodes := newCluster(3)
+		key := []byte("foo")
+		high := Ballot{Counter: 100, NodeID: 3}
+		for _, a := range nodes {
+			if _, err := a.Accept(high, key, []byte("bar")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err := nodes[1].Prepare(Ballot{Counter: 1, NodeID: 1}, key)
+		var conflict *ErrConflict
+		if !errors.As(err, &conflict) {
+			t.Fatalf("\nnode.Prepare() \ngot= %v, \nwant = %T", err, conflict)
+		}
+		want := &ErrConflict{AcceptorID: 2, Submitted: Ballot{Counter: 1, NodeID: 1}, Ballot: high, State: AcceptorState{AcceptedBallot: high, State: []byte("bar")}}
+		if !reflect.DeepEqual(conflict, want) {
+			t.Errorf("\nnode.Prepare() \ngot= %#+v, \nwant = %#+v", conflict, want)
+		}
+
+		nodes[0].AddRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		_, err = nodes[0].Propose(key, identityFunc)
+		var quorumErr *ErrQuorumNotReached
+		if !errors.As(err, &quorumErr) {
+			t.Fatalf("\nnode.Propose() \ngot= %v, \nwant = %T", err, quorumErr)
+		}
+		if quorumErr.Phase != PreparePhase || quorumErr.Ballot != high || len(quorumErr.Causes) == 0 {
+			t.Errorf("\nErrQuorumNotReached \ngot= %#+v", quorumErr)
+		}
+		for ID, cause := range quorumErr.Causes {
+			if !errors.Is(cause, &ErrConflict{}) {
+				t.Errorf("\ncause of acceptor:%v \ngot= %v, \nwant = %T", ID, cause, &ErrConflict{})
+			}
+		}
+	})
+}
+
+// failingTransport is a Transport that fails to deliver any message, but still returns a high Ballot with its errors.
+// prepares counts the prepare messages that it was given.
+type failingTransport struct {
+	prepares int64
+}
+
+func (ft *failingTransport) TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error) {
+	atomic.AddInt64(&ft.prepares, 1)
+	return AcceptorState{AcceptedBallot: Ballot{Counter: 1000, NodeID: 9}}, errors.New("connection refused")
+}
+
+func (ft *failingTransport) TransportAccept(ctx context.Context, b Ballot, key []byte, state []byte) (AcceptorState, error) {
+	return AcceptorState{AcceptedBallot: Ballot{Counter: 1000, NodeID: 9}}, errors.New("connection refused")
+}
+
+// wrappingTransport is an InmemTransport that wraps the errors of the acceptor, like a Transport that adds context to them would.
+type wrappingTransport struct {
+	InmemTransport
+}
+
+func (wt *wrappingTransport) TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error) {
+	acceptorState, err := wt.InmemTransport.TransportPrepare(ctx, b, key)
+	if err != nil {
+		return AcceptorState{}, pkgerrors.Wrap(err, "prepare failed")
+	}
+	return acceptorState, nil
+}
+
+func TestTransportFailures(t *testing.T) {
+	t.Run("delivery failures do not fast-forward", func(t *testing.T) {
+		nodes := []*Node{}
+		for i := uint64(1); i <= 3; i++ {
+			n := NewNode(i, &InmemStore{})
+			n.AddTransport(&failingTransport{})
+			nodes = append(nodes, n)
+		}
+		MingleNodes(nodes...)
+
+		_, err := nodes[0].Propose([]byte("foo"), identityFunc)
+		var quorumErr *ErrQuorumNotReached
+		if !errors.As(err, &quorumErr) {
+			t.Fatalf("\nnode.Propose() \ngot= %v, \nwant = %T", err, quorumErr)
+		}
+		if quorumErr.conflicted() || len(quorumErr.Causes) != 3 {
+			t.Errorf("\nErrQuorumNotReached.Causes \ngot= %v, \nwant = 3 delivery failures", quorumErr.Causes)
+		}
+		if nodes[0].Ballot.Counter >= 1000 {
+			t.Errorf("\nnode.Ballot \ngot= %#+v, \nwant less than = %#+v", nodes[0].Ballot.Counter, 1000)
+		}
+	})
+
+	t.Run("delivery failures are not retried", func(t *testing.T) {
+		nodes := []*Node{}
+		transports := []*failingTransport{}
+		for i := uint64(1); i <= 3; i++ {
+			n := NewNode(i, &InmemStore{})
+			ft := &failingTransport{}
+			n.AddTransport(ft)
+			n.AddRetryPolicy(RetryPolicy{MaxAttempts: 3})
+			nodes = append(nodes, n)
+			transports = append(transports, ft)
+		}
+		MingleNodes(nodes...)
+
+		_, err := nodes[0].Propose([]byte("foo"), identityFunc)
+		var quorumErr *ErrQuorumNotReached
+		if !errors.As(err, &quorumErr) {
+			t.Fatalf("\nnode.Propose() \ngot= %v, \nwant = %T", err, quorumErr)
+		}
+		for _, ft := range transports {
+			if got := atomic.LoadInt64(&ft.prepares); got != 1 {
+				t.Errorf("\nprepare messages \ngot= %v, \nwant = %v", got, 1)
+			}
+		}
+	})
+
+	t.Run("wrapped conflicts fast-forward", func(t *testing.T) {
+		nodes := []*Node{}
+		for i := uint64(1); i <= 3; i++ {
+			n := NewNode(i, &InmemStore{})
+			n.AddTransport(&wrappingTransport{InmemTransport: InmemTransport{Node: n}})
+			n.AddRetryPolicy(RetryPolicy{MaxAttempts: 2})
+			nodes = append(nodes, n)
+		}
+		MingleNodes(nodes...)
+		key := []byte("foo")
+		for _, a := range nodes {
+			if _, err := a.Accept(Ballot{Counter: 1000, NodeID: 3}, key, []byte("bar")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		newState, err := nodes[0].Propose(key, identityFunc)
+		if err != nil {
+			t.Fatalf("\nnode.Propose() \nerr = %v", err)
+		}
+		if string(newState) != "bar" {
+			t.Errorf("\nnode.Propose() \ngot= %s, \nwant = %s", newState, "bar")
+		}
+	})
+}
+
+func TestMarshalError(t *testing.T) {
+	conflict := &ErrConflict{
+		AcceptorID: 2,
+		Submitted:  Ballot{Counter: 1, NodeID: 1},
+		Ballot:     Ballot{Counter: 7, NodeID: 3, Epoch: 1},
+		Epoch:      1,
+		State:      AcceptorState{AcceptedBallot: Ballot{Counter: 7, NodeID: 3, Epoch: 1}, State: []byte("bar")},
+	}
+	tt := []struct {
+		name string
+		err  error
+	}{
+		{"conflict", conflict},
+		{"wrapped conflict", pkgerrors.Wrap(conflict, "prepare failed")},
+		{"quorum not reached", &ErrQuorumNotReached{Phase: AcceptPhase, Confirmations: 1, Acceptors: 3, Ballot: conflict.Ballot, Causes: map[uint64]error{2: conflict, 3: errors.New("connection refused")}}},
+		{"too few acceptors", &ErrTooFewAcceptors{Acceptors: 2, Minimum: 3}},
+		{"reserved key", &ErrReservedKey{Key: ballotCounterKey}},
+		{"change function", &ErrChangeFunction{Key: []byte("foo"), Err: errors.New("bad change")}},
+		{"other errors", errors.New("disk is full")},
+	}
+	for _, v := range tt {
+		t.Run(v.name, func(t *testing.T) {
+			data, err := MarshalError(v.err)
+			if err != nil {
+				t.Fatalf("\nMarshalError() \nerr = %v", err)
+			}
+			got := UnmarshalError(data)
+			if got.Error() != v.err.Error() {
+				t.Errorf("\nUnmarshalError() \ngot= %v, \nwant = %v", got, v.err)
+			}
+			if !reflect.DeepEqual(pkgerrors.Cause(got), pkgerrors.Cause(v.err)) {
+				t.Errorf("\nUnmarshalError() \ngot= %#+v, \nwant = %#+v", pkgerrors.Cause(got), pkgerrors.Cause(v.err))
+			}
+		})
+	}
+
+	if err := UnmarshalError([]byte("not an error")); err == nil {
+		t.Errorf("\nUnmarshalError() \nwanted an error for data that is not a serialized error")
+	}
+}
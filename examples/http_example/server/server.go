@@ -6,8 +6,8 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/hashicorp/raft-boltdb"
 	"github.com/komuw/kshaka"
+	"github.com/komuw/kshaka/boltStore"
 	"github.com/komuw/kshaka/httpTransport"
 )
 
@@ -25,10 +25,6 @@ var setFunc = func(val []byte) kshaka.ChangeFunction {
 	}
 }
 
-var readFunc kshaka.ChangeFunction = func(current []byte) ([]byte, error) {
-	return current, nil
-}
-
 func proposeHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		body, err := ioutil.ReadAll(r.Body)
@@ -43,13 +39,15 @@ func proposeHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		changeFunc := readFunc
+		var newState []byte
 		if proposeRequest.FunctionName == "setFunc" {
-			changeFunc = setFunc(proposeRequest.Val)
+			newState, err = n.Propose(proposeRequest.Key, setFunc(proposeRequest.Val))
+		} else {
+			// reads do not need to go through a full prepare and accept cycle.
+			newState, err = n.Read(proposeRequest.Key)
 		}
-		newState, err := n.Propose(proposeRequest.Key, changeFunc)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			httpTransport.WriteError(w, err)
 			return
 		}
 
@@ -77,7 +75,7 @@ func prepareHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request)
 
 		aState, err := n.Prepare(prepareRequest.B, prepareRequest.Key)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			httpTransport.WriteError(w, err)
 			return
 		}
 
@@ -109,11 +107,44 @@ func acceptHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request)
 		}
 
 		aState, err := n.Accept(acceptRequest.B, acceptRequest.Key, acceptRequest.State)
+		if err != nil {
+			httpTransport.WriteError(w, err)
+			return
+		}
+
+		acceptedState, err := json.Marshal(aState)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = w.Write(acceptedState)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func acceptPrepareHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		acceptPrepareRequest := httpTransport.AcceptPrepareRequest{}
+		err = json.Unmarshal(body, &acceptPrepareRequest)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		aState, err := n.AcceptPrepare(acceptPrepareRequest.B, acceptPrepareRequest.Key, acceptPrepareRequest.State, acceptPrepareRequest.Next)
+		if err != nil {
+			httpTransport.WriteError(w, err)
+			return
+		}
+
 		acceptedState, err := json.Marshal(aState)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -127,19 +158,160 @@ func acceptHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func configHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		config := kshaka.Config{}
+		err = json.Unmarshal(body, &config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		epoch, err := n.ApplyConfig(config)
+		if err != nil {
+			httpTransport.WriteError(w, err)
+			return
+		}
+
+		configResp, err := json.Marshal(httpTransport.ConfigResponse{Epoch: epoch})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = w.Write(configResp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func keysHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := n.Keys()
+		if err != nil {
+			httpTransport.WriteError(w, err)
+			return
+		}
+
+		keysResp, err := json.Marshal(httpTransport.KeysResponse{Keys: keys})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = w.Write(keysResp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func ageHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		age := kshaka.Age{}
+		err = json.Unmarshal(body, &age)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		epoch, err := n.BumpAge(age)
+		if err != nil {
+			httpTransport.WriteError(w, err)
+			return
+		}
+
+		ageResp, err := json.Marshal(httpTransport.ConfigResponse{Epoch: epoch})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = w.Write(ageResp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func tombstonesHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := n.Tombstones()
+		if err != nil {
+			httpTransport.WriteError(w, err)
+			return
+		}
+
+		keysResp, err := json.Marshal(httpTransport.KeysResponse{Keys: keys})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = w.Write(keysResp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func removeTombstoneHandler(n *kshaka.Node) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		removeReq := httpTransport.RemoveTombstoneRequest{}
+		err = json.Unmarshal(body, &removeReq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		removed, err := n.RemoveTombstone(removeReq.B, removeReq.Key)
+		if err != nil {
+			httpTransport.WriteError(w, err)
+			return
+		}
+
+		removeResp, err := json.Marshal(httpTransport.RemoveTombstoneResponse{Removed: removed})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = w.Write(removeResp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
 func main() {
 	// Create a store that will be used.
 	// Ideally it should be a disk persisted store.
-	// Any that implements hashicorp/raft StableStore interface will suffice
-	boltStore1, err := raftboltdb.NewBoltStore("/tmp/bolt1.db")
+	// Any that implements the kshaka.StableStore interface will suffice; those of hashicorp/raft can be wrapped in a kshaka.RaftStore
+	boltStore1, err := boltStore.Open("/tmp/bolt1.db", boltStore.Options{})
 	if err != nil {
 		panic(err)
 	}
-	boltStore2, err := raftboltdb.NewBoltStore("/tmp/bolt2.db")
+	boltStore2, err := boltStore.Open("/tmp/bolt2.db", boltStore.Options{})
 	if err != nil {
 		panic(err)
 	}
-	boltStore3, err := raftboltdb.NewBoltStore("/tmp/bolt3.db")
+	boltStore3, err := boltStore.Open("/tmp/bolt3.db", boltStore.Options{})
 	if err != nil {
 		panic(err)
 	}
@@ -151,23 +323,41 @@ func main() {
 	node3 := kshaka.NewNode(3, boltStore3)
 
 	transport1 := &httpTransport.HTTPtransport{
-		NodeAddrress: "127.0.0.1",
-		NodePort:     "15001",
-		ProposeURI:   "/propose",
-		PrepareURI:   "/prepare",
-		AcceptURI:    "/accept"}
+		NodeAddrress:       "127.0.0.1",
+		NodePort:           "15001",
+		ProposeURI:         "/propose",
+		PrepareURI:         "/prepare",
+		AcceptURI:          "/accept",
+		AcceptPrepareURI:   "/acceptPrepare",
+		ConfigURI:          "/config",
+		KeysURI:            "/keys",
+		AgeURI:             "/age",
+		TombstonesURI:      "/tombstones",
+		RemoveTombstoneURI: "/removeTombstone"}
 	transport2 := &httpTransport.HTTPtransport{
-		NodeAddrress: "127.0.0.1",
-		NodePort:     "15002",
-		ProposeURI:   "/propose",
-		PrepareURI:   "/prepare",
-		AcceptURI:    "/accept"}
+		NodeAddrress:       "127.0.0.1",
+		NodePort:           "15002",
+		ProposeURI:         "/propose",
+		PrepareURI:         "/prepare",
+		AcceptURI:          "/accept",
+		AcceptPrepareURI:   "/acceptPrepare",
+		ConfigURI:          "/config",
+		KeysURI:            "/keys",
+		AgeURI:             "/age",
+		TombstonesURI:      "/tombstones",
+		RemoveTombstoneURI: "/removeTombstone"}
 	transport3 := &httpTransport.HTTPtransport{
-		NodeAddrress: "127.0.0.1",
-		NodePort:     "15003",
-		ProposeURI:   "/propose",
-		PrepareURI:   "/prepare",
-		AcceptURI:    "/accept"}
+		NodeAddrress:       "127.0.0.1",
+		NodePort:           "15003",
+		ProposeURI:         "/propose",
+		PrepareURI:         "/prepare",
+		AcceptURI:          "/accept",
+		AcceptPrepareURI:   "/acceptPrepare",
+		ConfigURI:          "/config",
+		KeysURI:            "/keys",
+		AgeURI:             "/age",
+		TombstonesURI:      "/tombstones",
+		RemoveTombstoneURI: "/removeTombstone"}
 
 	node1.AddTransport(transport1)
 	node2.AddTransport(transport2)
@@ -178,6 +368,12 @@ func main() {
 	http.HandleFunc("/propose", proposeHandler(node1))
 	http.HandleFunc("/prepare", prepareHandler(node1))
 	http.HandleFunc("/accept", acceptHandler(node1))
+	http.HandleFunc("/acceptPrepare", acceptPrepareHandler(node1))
+	http.HandleFunc("/config", configHandler(node1))
+	http.HandleFunc("/keys", keysHandler(node1))
+	http.HandleFunc("/age", ageHandler(node1))
+	http.HandleFunc("/tombstones", tombstonesHandler(node1))
+	http.HandleFunc("/removeTombstone", removeTombstoneHandler(node1))
 
 	go func() {
 		log.Fatal(http.ListenAndServe(":15001", nil))
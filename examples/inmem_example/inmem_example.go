@@ -3,17 +3,19 @@ package main
 import (
 	"fmt"
 
-	"github.com/hashicorp/raft-boltdb"
 	"github.com/komuw/kshaka"
+	"github.com/komuw/kshaka/boltStore"
 )
 
 func main() {
 	// The store should, ideally be disk persisted.
-	// Any that implements hashicorp/raft StableStore interface will suffice
-	boltStore, err := raftboltdb.NewBoltStore("/tmp/bolt.db")
+	// Any that implements the kshaka.StableStore interface will suffice; those of hashicorp/raft can be
+	// wrapped in a kshaka.RaftStore so that missing keys are reported as kshaka.ErrNotFound
+	store, err := boltStore.Open("/tmp/bolt.db", boltStore.Options{})
 	if err != nil {
 		panic(err)
 	}
+	defer store.Close()
 
 	// The function that will be applied by CASPaxos.
 	// This will be applied to the current value stored
@@ -26,9 +28,9 @@ func main() {
 
 	// Note that, in practice, nodes ideally should be
 	// in different machines each with its own store.
-	node1 := kshaka.NewNode(1, boltStore)
-	node2 := kshaka.NewNode(2, boltStore)
-	node3 := kshaka.NewNode(3, boltStore)
+	node1 := kshaka.NewNode(1, store)
+	node2 := kshaka.NewNode(2, store)
+	node3 := kshaka.NewNode(3, store)
 
 	transport1 := &kshaka.InmemTransport{Node: node1}
 	transport2 := &kshaka.InmemTransport{Node: node2}
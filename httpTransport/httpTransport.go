@@ -6,6 +6,7 @@ package httpTransport
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	"github.com/komuw/kshaka"
+	"github.com/pkg/errors"
 )
 
 // HTTPtransport provides a http based transport that can be
@@ -23,6 +25,15 @@ type HTTPtransport struct {
 	ProposeURI   string
 	PrepareURI   string
 	AcceptURI    string
+	// AcceptPrepareURI is optional; it is only used by nodes that have enabled one round trip writes.
+	AcceptPrepareURI string
+	// ConfigURI and KeysURI are optional; they are only used by membership changes, see kshaka.Node.AddAcceptor
+	ConfigURI string
+	KeysURI   string
+	// AgeURI, TombstonesURI and RemoveTombstoneURI are optional; they are only used by garbage collection, see kshaka.Node.CollectGarbage
+	AgeURI             string
+	TombstonesURI      string
+	RemoveTombstoneURI string
 }
 
 // PrepareRequest is the request sent during prepare phase
@@ -33,37 +44,10 @@ type PrepareRequest struct {
 }
 
 // TransportPrepare implements the Transport interface.
-func (ht *HTTPtransport) TransportPrepare(b kshaka.Ballot, key []byte) (kshaka.AcceptorState, error) {
+func (ht *HTTPtransport) TransportPrepare(ctx context.Context, b kshaka.Ballot, key []byte) (kshaka.AcceptorState, error) {
 	acceptedState := kshaka.AcceptorState{}
-
-	prepReq := PrepareRequest{B: b, Key: key}
-	url := "http://" + ht.NodeAddrress + ":" + ht.NodePort + ht.PrepareURI
-	prepReqJSON, err := json.Marshal(prepReq)
-	if err != nil {
-		return acceptedState, err
-	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(prepReqJSON))
-	if err != nil {
-		return acceptedState, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	// todo: ideally, client should be resused across multiple requests
-	client := &http.Client{Timeout: time.Second * 3}
-	resp, err := client.Do(req)
-	if err != nil {
-		return acceptedState, err
-	}
-	defer resp.Body.Close() // nolint: errcheck
-	if resp.StatusCode != http.StatusOK {
-		return acceptedState, fmt.Errorf("url:%v returned http status:%v instead of status:%v", url, resp.StatusCode, http.StatusOK)
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return acceptedState, err
-	}
-
-	err = json.Unmarshal(body, &acceptedState)
-	return acceptedState, err
+	err := ht.post(ctx, ht.PrepareURI, PrepareRequest{B: b, Key: key}, &acceptedState)
+	return conflictState(acceptedState, err)
 }
 
 // AcceptRequest is the request sent during accept phase
@@ -75,33 +59,179 @@ type AcceptRequest struct {
 }
 
 // TransportAccept implements the Transport interface.
-func (ht *HTTPtransport) TransportAccept(b kshaka.Ballot, key []byte, state []byte) (kshaka.AcceptorState, error) {
+func (ht *HTTPtransport) TransportAccept(ctx context.Context, b kshaka.Ballot, key []byte, state []byte) (kshaka.AcceptorState, error) {
+	acceptedState := kshaka.AcceptorState{}
+	err := ht.post(ctx, ht.AcceptURI, AcceptRequest{B: b, Key: key, State: state}, &acceptedState)
+	return conflictState(acceptedState, err)
+}
+
+// AcceptPrepareRequest is the request sent during an accept phase that also carries the next prepare
+// specifically for the HTTPtransport
+type AcceptPrepareRequest struct {
+	B     kshaka.Ballot
+	Key   []byte
+	State []byte
+	Next  kshaka.Ballot
+}
+
+// TransportAcceptPrepare implements the AcceptPrepareTransport interface.
+func (ht *HTTPtransport) TransportAcceptPrepare(ctx context.Context, b kshaka.Ballot, key []byte, state []byte, next kshaka.Ballot) (kshaka.AcceptorState, error) {
 	acceptedState := kshaka.AcceptorState{}
-	acceptReq := AcceptRequest{B: b, Key: key, State: state}
-	url := "http://" + ht.NodeAddrress + ":" + ht.NodePort + ht.AcceptURI
-	acceptReqJSON, err := json.Marshal(acceptReq)
+	if ht.AcceptPrepareURI == "" {
+		return acceptedState, fmt.Errorf("HTTPtransport for node:%v has no AcceptPrepareURI", ht.NodeAddrress+":"+ht.NodePort)
+	}
+	err := ht.post(ctx, ht.AcceptPrepareURI, AcceptPrepareRequest{B: b, Key: key, State: state, Next: next}, &acceptedState)
+	return conflictState(acceptedState, err)
+}
+
+// ConfigResponse is the response to a configuration sent during a membership change
+// specifically for the HTTPtransport
+type ConfigResponse struct {
+	Epoch uint64
+}
+
+// TransportConfig implements the ConfigTransport interface.
+func (ht *HTTPtransport) TransportConfig(ctx context.Context, c kshaka.Config) (uint64, error) {
+	if ht.ConfigURI == "" {
+		return 0, fmt.Errorf("HTTPtransport for node:%v has no ConfigURI", ht.NodeAddrress+":"+ht.NodePort)
+	}
+	configResp := ConfigResponse{}
+	err := ht.post(ctx, ht.ConfigURI, c, &configResp)
+	return configResp.Epoch, err
+}
+
+// KeysResponse is the response to a request for the keys of an acceptor, sent during a membership change
+// specifically for the HTTPtransport
+type KeysResponse struct {
+	Keys [][]byte
+}
+
+// TransportKeys implements the ConfigTransport interface.
+func (ht *HTTPtransport) TransportKeys(ctx context.Context) ([][]byte, error) {
+	if ht.KeysURI == "" {
+		return nil, fmt.Errorf("HTTPtransport for node:%v has no KeysURI", ht.NodeAddrress+":"+ht.NodePort)
+	}
+	keysResp := KeysResponse{}
+	err := ht.post(ctx, ht.KeysURI, struct{}{}, &keysResp)
+	return keysResp.Keys, err
+}
+
+// TransportAge implements the ConfigTransport interface.
+// The reply is a ConfigResponse, like for TransportConfig.
+func (ht *HTTPtransport) TransportAge(ctx context.Context, a kshaka.Age) (uint64, error) {
+	if ht.AgeURI == "" {
+		return 0, fmt.Errorf("HTTPtransport for node:%v has no AgeURI", ht.NodeAddrress+":"+ht.NodePort)
+	}
+	configResp := ConfigResponse{}
+	err := ht.post(ctx, ht.AgeURI, a, &configResp)
+	return configResp.Epoch, err
+}
+
+// TransportTombstones implements the GCTransport interface.
+// The reply is a KeysResponse, like for TransportKeys.
+func (ht *HTTPtransport) TransportTombstones(ctx context.Context) ([][]byte, error) {
+	if ht.TombstonesURI == "" {
+		return nil, fmt.Errorf("HTTPtransport for node:%v has no TombstonesURI", ht.NodeAddrress+":"+ht.NodePort)
+	}
+	keysResp := KeysResponse{}
+	err := ht.post(ctx, ht.TombstonesURI, struct{}{}, &keysResp)
+	return keysResp.Keys, err
+}
+
+// RemoveTombstoneRequest is the request to remove a tombstone, sent during garbage collection
+// specifically for the HTTPtransport
+type RemoveTombstoneRequest struct {
+	B   kshaka.Ballot
+	Key []byte
+}
+
+// RemoveTombstoneResponse is the response to a RemoveTombstoneRequest
+// specifically for the HTTPtransport
+type RemoveTombstoneResponse struct {
+	Removed bool
+}
+
+// TransportRemoveTombstone implements the GCTransport interface.
+func (ht *HTTPtransport) TransportRemoveTombstone(ctx context.Context, b kshaka.Ballot, key []byte) (bool, error) {
+	if ht.RemoveTombstoneURI == "" {
+		return false, fmt.Errorf("HTTPtransport for node:%v has no RemoveTombstoneURI", ht.NodeAddrress+":"+ht.NodePort)
+	}
+	removeResp := RemoveTombstoneResponse{}
+	err := ht.post(ctx, ht.RemoveTombstoneURI, RemoveTombstoneRequest{B: b, Key: key}, &removeResp)
+	return removeResp.Removed, err
+}
+
+// post sends request, as json, to uri and decodes the json reply into response.
+// A reply that was written with WriteError is returned as the error instead.
+func (ht *HTTPtransport) post(ctx context.Context, uri string, request interface{}, response interface{}) error {
+	url := "http://" + ht.NodeAddrress + ":" + ht.NodePort + uri
+	reqJSON, err := json.Marshal(request)
 	if err != nil {
-		return acceptedState, err
+		return err
 	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(acceptReqJSON))
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqJSON))
 	if err != nil {
-		return acceptedState, err
+		return err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
+	// todo: ideally, client should be resused across multiple requests
 	client := &http.Client{Timeout: time.Second * 3}
 	resp, err := client.Do(req)
 	if err != nil {
-		return acceptedState, err
+		return err
 	}
 	defer resp.Body.Close() // nolint: errcheck
-	if resp.StatusCode != http.StatusOK {
-		return acceptedState, fmt.Errorf("url:%v returned http status:%v instead of status:%v", url, resp.StatusCode, http.StatusOK)
-	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return acceptedState, err
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return readError(url, resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, response)
+}
+
+// CanAcceptPrepare implements the AcceptPrepareTransport interface.
+// It reports whether the transport has an AcceptPrepareURI to send the messages to.
+func (ht *HTTPtransport) CanAcceptPrepare() bool {
+	return ht.AcceptPrepareURI != ""
+}
+
+// WriteError writes err to w in the form that HTTPtransport reads errors in.
+// Conflicts are written with http status 409 and any other error with http status 500.
+// Servers that use HTTPtransport should reply to the prepare and accept requests that fail with it.
+func WriteError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var conflict *kshaka.ErrConflict
+	if errors.As(err, &conflict) {
+		status = http.StatusConflict
 	}
+	body, marshalErr := kshaka.MarshalError(err)
+	if marshalErr != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body) // nolint: errcheck
+}
 
-	err = json.Unmarshal(body, &acceptedState)
-	return acceptedState, err
+// readError converts a response that was written with WriteError back into the error.
+func readError(url string, statusCode int, body []byte) error {
+	err := kshaka.UnmarshalError(body)
+	var conflict *kshaka.ErrConflict
+	if errors.As(err, &conflict) {
+		return err
+	}
+	return errors.Wrap(err, fmt.Sprintf("url:%v returned http status:%v instead of status:%v", url, statusCode, http.StatusOK))
+}
+
+// conflictState returns the acceptor state that came with err if it is a conflict, and state otherwise.
+func conflictState(state kshaka.AcceptorState, err error) (kshaka.AcceptorState, error) {
+	var conflict *kshaka.ErrConflict
+	if errors.As(err, &conflict) {
+		return conflict.State, err
+	}
+	return state, err
 }
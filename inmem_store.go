@@ -2,6 +2,8 @@ package kshaka
 
 import (
 	"errors"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -15,12 +17,17 @@ type InmemStore struct {
 	l     sync.RWMutex
 	kv    map[string][]byte
 	kvint map[string]uint64
+	// txnLocks locks the keys that transactions in progress have touched.
+	txnLocks keyLocker
 }
 
 // Set implements the StableStore interface.
 func (i *InmemStore) Set(key []byte, val []byte) error {
 	i.l.Lock()
 	defer i.l.Unlock()
+	if i.kv == nil {
+		i.kv = map[string][]byte{}
+	}
 	i.kv[string(key)] = val
 	return nil
 }
@@ -30,11 +37,8 @@ func (i *InmemStore) Get(key []byte) ([]byte, error) {
 	i.l.RLock()
 	defer i.l.RUnlock()
 	val := i.kv[string(key)]
-
-	// see: https://github.com/hashicorp/raft-boltdb/blob/6e5ba93211eaf8d9a2ad7e41ffad8c6f160f9fe3/bolt_store.go#L241-L246
-	// opened; hashicorp/raft/pull/286
 	if val == nil {
-		return nil, errors.New("not found")
+		return nil, ErrNotFound
 	}
 	return val, nil
 }
@@ -43,6 +47,9 @@ func (i *InmemStore) Get(key []byte) ([]byte, error) {
 func (i *InmemStore) SetUint64(key []byte, val uint64) error {
 	i.l.Lock()
 	defer i.l.Unlock()
+	if i.kvint == nil {
+		i.kvint = map[string]uint64{}
+	}
 	i.kvint[string(key)] = val
 	return nil
 }
@@ -53,3 +60,124 @@ func (i *InmemStore) GetUint64(key []byte) (uint64, error) {
 	defer i.l.RUnlock()
 	return i.kvint[string(key)], nil
 }
+
+// Delete implements the DeleteStore interface.
+func (i *InmemStore) Delete(key []byte) error {
+	i.l.Lock()
+	defer i.l.Unlock()
+	delete(i.kv, string(key))
+	return nil
+}
+
+// IteratePrefix implements the KeyIterator interface.
+// Keys are visited in lexicographical order, fn may write to the store.
+func (i *InmemStore) IteratePrefix(prefix []byte, fn func(key []byte, val []byte) error) error {
+	i.l.RLock()
+	keys := []string{}
+	for k, v := range i.kv {
+		if v != nil && strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	i.l.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		val, err := i.Get([]byte(k))
+		if err != nil {
+			// the key was removed while we were iterating.
+			continue
+		}
+		err = fn([]byte(k), val)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Begin implements the TxnStore interface.
+// A transaction locks each key that it touches until it is committed or rolled back, so transactions on different keys run in parallel.
+// Transactions that touch the same keys in a different order can deadlock; a node touches one key per transaction.
+func (i *InmemStore) Begin() (Txn, error) {
+	return &inmemTxn{store: i, writes: map[string][]byte{}, locked: map[*sync.Mutex]bool{}}, nil
+}
+
+// inmemTxn is a transaction of an InmemStore. Its writes are buffered until Commit; a nil value marks a deleted key.
+type inmemTxn struct {
+	store  *InmemStore
+	writes map[string][]byte
+	locked map[*sync.Mutex]bool
+	done   bool
+}
+
+// lock locks key for the rest of the transaction; keys that share a lock only take it once.
+func (t *inmemTxn) lock(key []byte) {
+	mu := t.store.txnLocks.stripe(key)
+	if !t.locked[mu] {
+		mu.Lock()
+		t.locked[mu] = true
+	}
+}
+
+// unlock releases the keys that the transaction has locked.
+func (t *inmemTxn) unlock() {
+	for mu := range t.locked {
+		mu.Unlock()
+	}
+	t.locked = nil
+}
+
+func (t *inmemTxn) Get(key []byte) ([]byte, error) {
+	t.lock(key)
+	if val, ok := t.writes[string(key)]; ok {
+		if val == nil {
+			return nil, ErrNotFound
+		}
+		return val, nil
+	}
+	return t.store.Get(key)
+}
+
+func (t *inmemTxn) Set(key []byte, val []byte) error {
+	t.lock(key)
+	t.writes[string(key)] = val
+	return nil
+}
+
+func (t *inmemTxn) Delete(key []byte) error {
+	t.lock(key)
+	t.writes[string(key)] = nil
+	return nil
+}
+
+func (t *inmemTxn) Commit() error {
+	if t.done {
+		return errors.New("the transaction has already been committed or rolled back")
+	}
+	t.done = true
+	defer t.unlock()
+
+	t.store.l.Lock()
+	defer t.store.l.Unlock()
+	if t.store.kv == nil {
+		t.store.kv = map[string][]byte{}
+	}
+	for k, val := range t.writes {
+		if val == nil {
+			delete(t.store.kv, k)
+		} else {
+			t.store.kv[k] = val
+		}
+	}
+	return nil
+}
+
+func (t *inmemTxn) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	t.unlock()
+	return nil
+}
@@ -1,5 +1,9 @@
 package kshaka
 
+import (
+	"context"
+)
+
 // InmemTransport Implements the Transport interface, to allow kshaka/CASPaxos to be
 // tested in-memory without going over a network.
 type InmemTransport struct {
@@ -7,11 +11,70 @@ type InmemTransport struct {
 }
 
 // TransportPrepare implements the Transport interface.
-func (it *InmemTransport) TransportPrepare(b Ballot, key []byte) (AcceptorState, error) {
+func (it *InmemTransport) TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error) {
+	if err := ctx.Err(); err != nil {
+		return AcceptorState{}, err
+	}
 	return it.Node.Prepare(b, key)
 }
 
 // TransportAccept implements the Transport interface.
-func (it *InmemTransport) TransportAccept(b Ballot, key []byte, state []byte) (AcceptorState, error) {
+func (it *InmemTransport) TransportAccept(ctx context.Context, b Ballot, key []byte, state []byte) (AcceptorState, error) {
+	if err := ctx.Err(); err != nil {
+		return AcceptorState{}, err
+	}
 	return it.Node.Accept(b, key, state)
 }
+
+// TransportAcceptPrepare implements the AcceptPrepareTransport interface.
+func (it *InmemTransport) TransportAcceptPrepare(ctx context.Context, b Ballot, key []byte, state []byte, next Ballot) (AcceptorState, error) {
+	if err := ctx.Err(); err != nil {
+		return AcceptorState{}, err
+	}
+	return it.Node.AcceptPrepare(b, key, state, next)
+}
+
+// CanAcceptPrepare implements the AcceptPrepareTransport interface.
+func (it *InmemTransport) CanAcceptPrepare() bool {
+	return true
+}
+
+// TransportConfig implements the ConfigTransport interface.
+func (it *InmemTransport) TransportConfig(ctx context.Context, c Config) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return it.Node.ApplyConfig(c)
+}
+
+// TransportKeys implements the ConfigTransport interface.
+func (it *InmemTransport) TransportKeys(ctx context.Context) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return it.Node.Keys()
+}
+
+// TransportAge implements the ConfigTransport interface.
+func (it *InmemTransport) TransportAge(ctx context.Context, a Age) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return it.Node.BumpAge(a)
+}
+
+// TransportTombstones implements the GCTransport interface.
+func (it *InmemTransport) TransportTombstones(ctx context.Context) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return it.Node.Tombstones()
+}
+
+// TransportRemoveTombstone implements the GCTransport interface.
+func (it *InmemTransport) TransportRemoveTombstone(ctx context.Context, b Ballot, key []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return it.Node.RemoveTombstone(b, key)
+}
@@ -0,0 +1,74 @@
+package kshaka
+
+// defaultKeyBallots is the number of keys whose Ballot counters are kept, unless configured otherwise.
+const defaultKeyBallots = 10000
+
+// keyBallots is a bounded, least recently used, cache of the Ballot counter of each key.
+// It is not safe for concurrent use; a Node guards it with ballotMu.
+type keyBallots struct {
+	counters *lru
+}
+
+func newKeyBallots(size int) *keyBallots {
+	if size < 1 {
+		size = defaultKeyBallots
+	}
+	return &keyBallots{counters: newLRU(size)}
+}
+
+func (kb *keyBallots) get(key []byte) (uint64, bool) {
+	counter, ok := kb.counters.get(key)
+	if !ok {
+		return 0, false
+	}
+	return counter.(uint64), true
+}
+
+// set sets the counter of key. If that evicts the least recently used key, set returns its counter and true.
+func (kb *keyBallots) set(key []byte, counter uint64) (uint64, bool) {
+	evicted, ok := kb.counters.set(key, counter)
+	if !ok {
+		return 0, false
+	}
+	return evicted.(uint64), true
+}
+
+// EnablePerKeyBallots makes the node keep a separate Ballot counter for each key, instead of one counter for all the keys.
+// A conflict on one key then only fast-forwards the counter of that key, so the Ballots of each key evolve independently.
+// This is safe since Ballots only have to be unique and increasing per key; any subsequence of such a sequence is too.
+//
+// The counters of at most maxKeys keys are kept, in a least recently used cache; zero means a default of 10000.
+// The node's Ballot then acts as the floor of all the counters: keys that are not in the cache start from it,
+// and it is raised to the counter of each key that is evicted from the cache.
+// Counters are persisted, like the node's Ballot, by reserving batches of counters in the node's store.
+// EnablePerKeyBallots should be called before the node starts proposing.
+func (n *Node) EnablePerKeyBallots(maxKeys int) {
+	n.ballotMu.Lock()
+	defer n.ballotMu.Unlock()
+	n.keyBallots = newKeyBallots(maxKeys)
+}
+
+// keyCounter returns the current Ballot counter of key. ballotMu must be held.
+func (n *Node) keyCounter(key []byte) uint64 {
+	if n.keyBallots == nil {
+		return n.Ballot.Counter
+	}
+	counter, ok := n.keyBallots.get(key)
+	if !ok || counter < n.Ballot.Counter {
+		return n.Ballot.Counter
+	}
+	return counter
+}
+
+// setKeyCounter sets the current Ballot counter of key. ballotMu must be held.
+func (n *Node) setKeyCounter(key []byte, counter uint64) {
+	if n.keyBallots == nil {
+		n.Ballot.Counter = counter
+		return
+	}
+	evicted, ok := n.keyBallots.set(key, counter)
+	if ok && evicted > n.Ballot.Counter {
+		// the key may be used again, so its next Ballot has to be greater than the ones it has had.
+		n.Ballot.Counter = evicted
+	}
+}
@@ -0,0 +1,105 @@
+package kshaka
+
+import (
+	"testing"
+)
+
+func TestPerKeyBallots(t *testing.T) {
+	hot, cold := []byte("hot"), []byte("cold")
+
+	t.Run("keys are independent", func(t *testing.T) {
+		n := NewNode(1, &InmemStore{})
+		n.EnablePerKeyBallots(0)
+		n.fastForward(hot, Ballot{Counter: 1000, NodeID: 2})
+
+		b, err := n.incBallot(hot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Counter != 1001 {
+			t.Errorf("\n n.incBallot(hot) \ngot = %#+v, \nwanted = %#+v", b.Counter, 1001)
+		}
+		b, err = n.incBallot(cold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Counter != 1 {
+			t.Errorf("\n n.incBallot(cold) \ngot = %#+v, \nwanted = %#+v", b.Counter, 1)
+		}
+	})
+
+	t.Run("evicted keys raise the floor", func(t *testing.T) {
+		n := NewNode(1, &InmemStore{})
+		n.EnablePerKeyBallots(2)
+		n.fastForward(hot, Ballot{Counter: 500, NodeID: 2})
+		for _, key := range []string{"a", "b"} {
+			if _, err := n.incBallot([]byte(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n.Ballot.Counter != 500 {
+			t.Errorf("\n Ballot floor \ngot = %#+v, \nwanted = %#+v", n.Ballot.Counter, 500)
+		}
+		for _, key := range [][]byte{hot, cold} {
+			b, err := n.incBallot(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Counter <= 500 {
+				t.Errorf("\n n.incBallot(%s) \ngot = %#+v, \nwanted greater than = %#+v", key, b.Counter, 500)
+			}
+		}
+	})
+
+	t.Run("restarted node", func(t *testing.T) {
+		store := &InmemStore{}
+		n := NewNode(1, store)
+		n.EnablePerKeyBallots(0)
+		n.fastForward(hot, Ballot{Counter: 1500, NodeID: 2})
+		issued, err := n.incBallot(hot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restarted := NewNode(1, store)
+		restarted.EnablePerKeyBallots(0)
+		b, err := restarted.incBallot(hot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !issued.Less(b) {
+			t.Errorf("\n restarted node reused Ballot \ngot = %#+v, \nwanted greater than = %#+v", b, issued)
+		}
+	})
+
+	t.Run("conflicts only fast-forward their key", func(t *testing.T) {
+		nodes := []*Node{}
+		for i := uint64(1); i <= 3; i++ {
+			n := NewNode(i, &InmemStore{})
+			n.AddTransport(&InmemTransport{Node: n})
+			n.AddRetryPolicy(RetryPolicy{MaxAttempts: 3})
+			n.EnablePerKeyBallots(0)
+			nodes = append(nodes, n)
+		}
+		MingleNodes(nodes...)
+
+		// another proposer has used a high Ballot on the hot key.
+		for _, a := range nodes {
+			if _, err := a.Accept(Ballot{Counter: 5000, NodeID: 3}, hot, []byte("bar")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, key := range [][]byte{hot, cold} {
+			if _, err := nodes[0].Propose(key, identityFunc); err != nil {
+				t.Fatalf("\nnode.Propose(%s) \nerr = %v", key, err)
+			}
+		}
+		acceptorState, err := nodes[1].getAcceptorState(cold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acceptorState.AcceptedBallot.Counter >= 5000 {
+			t.Errorf("\n accepted Ballot of cold key \ngot = %#+v, \nwanted less than = %#+v", acceptorState.AcceptedBallot.Counter, 5000)
+		}
+	})
+}
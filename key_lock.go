@@ -0,0 +1,41 @@
+package kshaka
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// defaultKeyLockStripes is the number of locks that keys are spread across, unless configured otherwise.
+const defaultKeyLockStripes = 1024
+
+// keyLocker makes operations affecting the same key mutually exclusive.
+// Keys are hashed onto a fixed number of mutexes(stripes), so operations on the same key are always serialized
+// whereas operations on different keys run in parallel unless their keys happen to share a stripe.
+// The zero value is ready to use and has defaultKeyLockStripes stripes.
+type keyLocker struct {
+	once    sync.Once
+	size    int // number of stripes; set before first use to override defaultKeyLockStripes.
+	stripes []sync.Mutex
+}
+
+func (kl *keyLocker) init() {
+	if kl.size < 1 {
+		kl.size = defaultKeyLockStripes
+	}
+	kl.stripes = make([]sync.Mutex, kl.size)
+}
+
+// lock locks the stripe that key belongs to and returns the function that unlocks it.
+func (kl *keyLocker) lock(key []byte) func() {
+	mu := kl.stripe(key)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// stripe returns the mutex that key is hashed onto.
+func (kl *keyLocker) stripe(key []byte) *sync.Mutex {
+	kl.once.Do(kl.init)
+	h := fnv.New32a()
+	_, _ = h.Write(key) // hash.Hash never returns an error.
+	return &kl.stripes[h.Sum32()%uint32(len(kl.stripes))]
+}
@@ -0,0 +1,175 @@
+package kshaka
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWeight is the weight that each new round trip has in the moving average of the latency to an acceptor.
+const latencyWeight = 0.2
+
+// fastestQuorumWait is how many round trips, to the slowest acceptor of the fastest quorum, a node waits for that quorum to reply
+// before it sends its message to the other acceptors as well. minFastestQuorumWait is the least it waits.
+const (
+	fastestQuorumWait    = 2
+	minFastestQuorumWait = time.Millisecond
+)
+
+// latencies tracks an exponentially weighted moving average of the round trip time to each acceptor.
+// The zero value is ready to use.
+type latencies struct {
+	mu  sync.Mutex
+	rtt map[uint64]time.Duration
+}
+
+func (l *latencies) observe(ID uint64, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rtt == nil {
+		l.rtt = map[uint64]time.Duration{}
+	}
+	old, ok := l.rtt[ID]
+	if !ok {
+		l.rtt[ID] = d
+		return
+	}
+	l.rtt[ID] = old + time.Duration(latencyWeight*float64(d-old))
+}
+
+func (l *latencies) get(ID uint64) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d, ok := l.rtt[ID]
+	return d, ok
+}
+
+// Latency returns the moving average of the round trip time from the node to the acceptor with the given ID,
+// and whether the node has exchanged any messages with that acceptor yet.
+func (n *Node) Latency(ID uint64) (time.Duration, bool) {
+	return n.latencies.get(ID)
+}
+
+// EnableFastestQuorums makes the node send the messages of each phase to the fastest quorum of acceptors only,
+// instead of to every acceptor. The other acceptors are sent the message as well if that quorum does not reply in time,
+// ie within twice the latency of its slowest acceptor, or if it replies without forming a quorum; eg due to conflicts.
+// Until the node has measured the latency to every acceptor, it sends to all of them.
+// It saves the acceptors, and the network, a message per acceptor outside the fastest quorum; at the cost of a longer wait whenever that quorum fails.
+// Acceptors that are left out fall behind, until a fallback or a membership change catches them up.
+func (n *Node) EnableFastestQuorums() {
+	n.fastestQuorums = true
+}
+
+// byLatency returns the acceptors sorted by their latency from the node, lowest first.
+// Acceptors whose latency is not known yet come first, so that they get measured.
+func (n *Node) byLatency(acceptors []*Node) []*Node {
+	type acceptorLatency struct {
+		acceptor *Node
+		latency  time.Duration
+	}
+	sorted := make([]acceptorLatency, 0, len(acceptors))
+	for _, a := range acceptors {
+		d, _ := n.latencies.get(a.ID)
+		sorted = append(sorted, acceptorLatency{a, d})
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].latency < sorted[j].latency })
+
+	result := make([]*Node, 0, len(acceptors))
+	for _, al := range sorted {
+		result = append(result, al.acceptor)
+	}
+	return result
+}
+
+// fastestQuorum splits the acceptors into the fewest of them, lowest latency first, that are a quorum for phase and the others;
+// and returns how long to wait for that quorum to reply. All the acceptors are in the quorum if the latency of any of them is not known yet.
+func (n *Node) fastestQuorum(phase Phase, quorum QuorumSystem, acceptors []*Node) ([]*Node, []*Node, time.Duration) {
+	sorted := n.byLatency(acceptors)
+	var slowest time.Duration
+	for i, a := range sorted {
+		d, ok := n.latencies.get(a.ID)
+		if !ok {
+			return acceptors, nil, 0
+		}
+		if d > slowest {
+			slowest = d
+		}
+		if quorum.IsQuorum(phase, acceptors, sorted[:i+1]) {
+			wait := fastestQuorumWait * slowest
+			if wait < minFastestQuorumWait {
+				wait = minFastestQuorumWait
+			}
+			return sorted[:i+1], sorted[i+1:], wait
+		}
+	}
+	return acceptors, nil, 0
+}
+
+// fanOut sends the message of a phase to the acceptors; to the fastest quorum first, if the node is set to, and then to the others.
+type fanOut struct {
+	send    func(a *Node)
+	rest    []*Node
+	pending int
+	timer   *time.Timer
+}
+
+// startFanOut calls send for the acceptors that the message of phase goes to first; send should not block.
+// Unless the node sends to the fastest quorum first, those are all the acceptors.
+func (n *Node) startFanOut(phase Phase, quorum QuorumSystem, acceptors []*Node, send func(a *Node)) *fanOut {
+	f := &fanOut{send: send}
+	first := acceptors
+	if n.fastestQuorums {
+		var wait time.Duration
+		first, f.rest, wait = n.fastestQuorum(phase, quorum, acceptors)
+		if len(f.rest) > 0 {
+			f.timer = time.NewTimer(wait)
+		}
+	}
+	for _, a := range first {
+		send(a)
+	}
+	f.pending = len(first)
+	return f
+}
+
+// more reports whether there are replies left to wait for. Once all the acceptors that were sent the message have replied,
+// the others are sent it too. It should only be called while a quorum has not been reached.
+func (f *fanOut) more() bool {
+	if f.pending == 0 {
+		if len(f.rest) == 0 {
+			return false
+		}
+		f.sendRest()
+	}
+	return true
+}
+
+// received records a reply.
+func (f *fanOut) received() {
+	f.pending--
+}
+
+// timeout fires when the node should stop waiting for the fastest quorum, it is nil if there is nobody else to send the message to.
+func (f *fanOut) timeout() <-chan time.Time {
+	if f.timer == nil {
+		return nil
+	}
+	return f.timer.C
+}
+
+// sendRest sends the message to the acceptors that were left out.
+func (f *fanOut) sendRest() {
+	f.stop()
+	f.timer = nil
+	for _, a := range f.rest {
+		f.send(a)
+	}
+	f.pending = f.pending + len(f.rest)
+	f.rest = nil
+}
+
+func (f *fanOut) stop() {
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+}
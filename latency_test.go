@@ -0,0 +1,148 @@
+package kshaka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowTransport is an InmemTransport to an acceptor that takes delay to reply.
+type slowTransport struct {
+	InmemTransport
+	delay time.Duration
+}
+
+func (st *slowTransport) TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error) {
+	time.Sleep(st.delay)
+	return st.InmemTransport.TransportPrepare(ctx, b, key)
+}
+
+func (st *slowTransport) TransportAccept(ctx context.Context, b Ballot, key []byte, state []byte) (AcceptorState, error) {
+	time.Sleep(st.delay)
+	return st.InmemTransport.TransportAccept(ctx, b, key, state)
+}
+
+func TestLatency(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+	delays := []time.Duration{20 * time.Millisecond, 0, 10 * time.Millisecond}
+	nodes := []*Node{}
+	for i, delay := range delays {
+		n := NewNode(uint64(i+1), &InmemStore{})
+		n.AddTransport(&slowTransport{InmemTransport: InmemTransport{Node: n}, delay: delay})
+		nodes = append(nodes, n)
+	}
+	MingleNodes(nodes...)
+
+	proposer := nodes[0]
+	if _, ok := proposer.Latency(1); ok {
+		t.Errorf("\nnode.Latency() \nwanted no latency before any message is sent")
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := proposer.Propose([]byte("foo"), setFunc([]byte("bar"))); err != nil {
+			t.Fatalf("\nnode.Propose() \nerr = %v", err)
+		}
+	}
+	// wait for the slowest acceptor to reply to the last accept message.
+	time.Sleep(50 * time.Millisecond)
+
+	for i, delay := range delays {
+		latency, ok := proposer.Latency(uint64(i + 1))
+		if !ok {
+			t.Fatalf("\nnode.Latency(%v) \nwanted a latency after proposing", i+1)
+		}
+		if latency < delay {
+			t.Errorf("\nnode.Latency(%v) \ngot= %v, \nwant >= %v", i+1, latency, delay)
+		}
+	}
+	got := proposer.byLatency(nodes)
+	want := []*Node{nodes[1], nodes[2], nodes[0]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nnode.byLatency() \ngot= %v, \nwant = %v", nodeIDs(got), nodeIDs(want))
+	}
+}
+
+// countingTransport is a slowTransport that counts the messages it is given, and fails them while down is set.
+type countingTransport struct {
+	slowTransport
+	messages int64
+	down     int32
+}
+
+func (ct *countingTransport) TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error) {
+	atomic.AddInt64(&ct.messages, 1)
+	if atomic.LoadInt32(&ct.down) == 1 {
+		return AcceptorState{}, errors.New("acceptor is down")
+	}
+	return ct.slowTransport.TransportPrepare(ctx, b, key)
+}
+
+func (ct *countingTransport) TransportAccept(ctx context.Context, b Ballot, key []byte, state []byte) (AcceptorState, error) {
+	atomic.AddInt64(&ct.messages, 1)
+	if atomic.LoadInt32(&ct.down) == 1 {
+		return AcceptorState{}, errors.New("acceptor is down")
+	}
+	return ct.slowTransport.TransportAccept(ctx, b, key, state)
+}
+
+func TestFastestQuorums(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+	// the delays are far apart, so that the fastest quorum replies well within the time that the node waits for it.
+	delays := []time.Duration{200 * time.Millisecond, 0, 20 * time.Millisecond}
+	nodes := []*Node{}
+	transports := []*countingTransport{}
+	for i, delay := range delays {
+		n := NewNode(uint64(i+1), &InmemStore{})
+		ct := &countingTransport{slowTransport: slowTransport{InmemTransport: InmemTransport{Node: n}, delay: delay}}
+		n.AddTransport(ct)
+		nodes = append(nodes, n)
+		transports = append(transports, ct)
+	}
+	MingleNodes(nodes...)
+	proposer := nodes[1]
+	proposer.EnableFastestQuorums()
+	key := []byte("foo")
+
+	// the latencies are not known yet, so every acceptor is sent the messages.
+	if _, err := proposer.Propose(key, setFunc([]byte("a"))); err != nil {
+		t.Fatalf("\nnode.Propose() \nerr = %v", err)
+	}
+	// wait for the slowest acceptor to reply to the last accept message.
+	time.Sleep(250 * time.Millisecond)
+	if got := atomic.LoadInt64(&transports[0].messages); got != 2 {
+		t.Errorf("\nmessages to the slowest acceptor \ngot= %v, \nwant = %v", got, 2)
+	}
+
+	// the slowest acceptor is left out of the fastest quorum.
+	for _, val := range []string{"b", "c"} {
+		if _, err := proposer.Propose(key, setFunc([]byte(val))); err != nil {
+			t.Fatalf("\nnode.Propose() \nerr = %v", err)
+		}
+	}
+	if got := atomic.LoadInt64(&transports[0].messages); got != 2 {
+		t.Errorf("\nmessages to the slowest acceptor \ngot= %v, \nwant = %v", got, 2)
+	}
+
+	// it is sent the messages once an acceptor of the fastest quorum fails.
+	atomic.StoreInt32(&transports[2].down, 1)
+	newstate, err := proposer.Propose(key, setFunc([]byte("d")))
+	if err != nil {
+		t.Fatalf("\nnode.Propose() \nerr = %v", err)
+	}
+	if !reflect.DeepEqual(newstate, []byte("d")) {
+		t.Errorf("\nnode.Propose() \ngot= %s, \nwant = %s", newstate, "d")
+	}
+	if got := atomic.LoadInt64(&transports[0].messages); got != 4 {
+		t.Errorf("\nmessages to the slowest acceptor \ngot= %v, \nwant = %v", got, 4)
+	}
+}
@@ -0,0 +1,60 @@
+package kshaka
+
+import (
+	"container/list"
+)
+
+// lru is a bounded, least recently used, cache of values by key.
+// It is not safe for concurrent use.
+type lru struct {
+	size     int
+	order    *list.List // front is the most recently used key.
+	elements map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), elements: map[string]*list.Element{}}
+}
+
+func (c *lru) get(key []byte) (interface{}, bool) {
+	e, ok := c.elements[string(key)]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// set sets the value of key. If that evicts the least recently used key, set returns its value and true.
+func (c *lru) set(key []byte, value interface{}) (interface{}, bool) {
+	if e, ok := c.elements[string(key)]; ok {
+		e.Value.(*lruEntry).value = value
+		c.order.MoveToFront(e)
+		return nil, false
+	}
+	c.elements[string(key)] = c.order.PushFront(&lruEntry{key: string(key), value: value})
+	if c.order.Len() <= c.size {
+		return nil, false
+	}
+	oldest := c.order.Back()
+	c.order.Remove(oldest)
+	evicted := oldest.Value.(*lruEntry)
+	delete(c.elements, evicted.key)
+	return evicted.value, true
+}
+
+// remove removes key, and returns the value that it had.
+func (c *lru) remove(key []byte) (interface{}, bool) {
+	e, ok := c.elements[string(key)]
+	if !ok {
+		return nil, false
+	}
+	c.order.Remove(e)
+	delete(c.elements, string(key))
+	return e.Value.(*lruEntry).value, true
+}
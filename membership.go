@@ -0,0 +1,412 @@
+package kshaka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Cluster membership change follows the procedure in the CASPaxos paper.
+
+To add an acceptor to a cluster of 2F+1 acceptors:
+  1. every proposer is updated to send accept messages to the 2F+2 acceptors and to wait for F+2 confirmations,
+     while prepare messages still go to the old 2F+1 acceptors.
+  2. every key is re-read, with the identity ChangeFunction, so that its value reaches the new acceptor through the new quorum.
+  3. every proposer is updated to also send prepare messages to the 2F+2 acceptors and to wait for F+2 confirmations.
+Going from 2F+2 to 2F+3 acceptors is the same procedure.
+Removing an acceptor is the reverse: prepare messages stop going to it first, then every key is re-read
+and finally accept messages stop going to it.
+
+Every step bumps the configuration epoch of all the proposers and acceptors(every Node is both),
+acceptors reject the Ballots of proposers that are still running an older configuration.
+The configurations, and the requests for the keys of each acceptor, are sent over the Transport; see ConfigTransport.
+Each node persists the epoch that it runs in the meta namespace of its store, so that it still rejects older Ballots after a restart.
+*/
+
+// catchUpRetryPolicy is used to re-read keys during membership changes, unless the node's own RetryPolicy allows more attempts.
+// Conflicts are to be expected, since the keys are re-read while the cluster keeps serving proposals.
+var catchUpRetryPolicy = RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Millisecond, MaxBackoff: 100 * time.Millisecond, Jitter: 0.5}
+
+// prepareAcceptors returns the acceptors that prepare messages are sent to.
+func (n *Node) prepareAcceptors() []*Node {
+	n.configMu.RLock()
+	defer n.configMu.RUnlock()
+	acceptors := []*Node{}
+	for _, a := range n.nodes {
+		if !containsNode(n.leaving, a) {
+			acceptors = append(acceptors, a)
+		}
+	}
+	return acceptors
+}
+
+// acceptAcceptors returns the acceptors that accept messages are sent to.
+func (n *Node) acceptAcceptors() []*Node {
+	n.configMu.RLock()
+	defer n.configMu.RUnlock()
+	return removeDuplicatesNodes(append(append([]*Node{}, n.nodes...), n.joining...))
+}
+
+// epochMetaKey is the key of the meta namespace under which a node persists the epoch of the configuration that it runs,
+// so that a restarted acceptor keeps rejecting the Ballots of older configurations.
+var epochMetaKey = []byte("epoch")
+
+// currentEpoch returns the epoch of the configuration that the node runs, restoring it from the node's store the first time.
+func (n *Node) currentEpoch() (uint64, error) {
+	n.configMu.RLock()
+	epoch, loaded := n.epoch, n.epochLoaded
+	n.configMu.RUnlock()
+	if loaded {
+		return epoch, nil
+	}
+	n.configMu.Lock()
+	defer n.configMu.Unlock()
+	err := n.loadEpoch()
+	return n.epoch, err
+}
+
+// loadEpoch restores the epoch from the node's store, unless it already has.
+// configMu must be held.
+func (n *Node) loadEpoch() error {
+	if n.epochLoaded {
+		return nil
+	}
+	epoch, err := n.metaStore().GetUint64(epochMetaKey)
+	if errors.Is(err, ErrNotFound) {
+		epoch, err = 0, nil
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to get the configuration epoch of node:%v", n.ID))
+	}
+	if n.epoch < epoch {
+		n.epoch = epoch
+	}
+	n.epochLoaded = true
+	return nil
+}
+
+// storeEpoch persists epoch, if it is newer than the epoch that the node runs, and makes the node run it.
+// configMu must be held.
+func (n *Node) storeEpoch(epoch uint64) error {
+	err := n.loadEpoch()
+	if err != nil {
+		return err
+	}
+	if epoch <= n.epoch {
+		return nil
+	}
+	err = n.metaStore().SetUint64(epochMetaKey, epoch)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to persist the configuration epoch of node:%v", n.ID))
+	}
+	n.epoch = epoch
+	return nil
+}
+
+// Config is a configuration of the acceptors of a cluster, as sent to every node during a membership change.
+// Acceptors are named by their IDs, a node that is sent a Config has to know each of them; see Node.AddPeer
+type Config struct {
+	// Epoch is the version of the configuration, each membership change makes a newer one.
+	Epoch uint64
+	// Nodes are the acceptors of the cluster, Joining and Leaving are the acceptors that the change is adding and removing.
+	Nodes   []uint64
+	Joining []uint64
+	Leaving []uint64
+}
+
+// AddPeer lets the node know of p, and how to reach it through p.Trans, without making p an acceptor of the node's cluster.
+// A node can only run the configurations whose acceptors it knows of; through MingleNodes, AddPeer or an earlier configuration.
+// Before a membership change adds an acceptor, every node of the cluster has to be introduced to it, and it to them.
+func (n *Node) AddPeer(p *Node) {
+	n.configMu.Lock()
+	defer n.configMu.Unlock()
+	if n.peers == nil {
+		n.peers = map[uint64]*Node{}
+	}
+	n.peers[p.ID] = p
+}
+
+// peer returns the node with the given ID, out of the nodes that the node knows of; including itself.
+// configMu must be held.
+func (n *Node) peer(ID uint64) (*Node, bool) {
+	if ID == n.ID {
+		return n, true
+	}
+	for _, nodes := range [][]*Node{n.nodes, n.joining, n.leaving} {
+		for _, p := range nodes {
+			if p.ID == ID {
+				return p, true
+			}
+		}
+	}
+	p, ok := n.peers[ID]
+	return p, ok
+}
+
+// ApplyConfig handles a configuration sent by the node that runs a membership change.
+// The node starts running c, unless it already runs a newer configuration; either way it returns the epoch that it runs.
+func (n *Node) ApplyConfig(c Config) (uint64, error) {
+	n.configMu.Lock()
+	defer n.configMu.Unlock()
+	err := n.loadEpoch()
+	if err != nil {
+		return 0, err
+	}
+	if c.Epoch < n.epoch {
+		return n.epoch, nil
+	}
+
+	resolved := [][]*Node{}
+	for _, IDs := range [][]uint64{c.Nodes, c.Joining, c.Leaving} {
+		nodes := []*Node{}
+		for _, ID := range IDs {
+			p, ok := n.peer(ID)
+			if !ok {
+				return 0, fmt.Errorf("node:%v does not know acceptor:%v of configuration epoch:%v", n.ID, ID, c.Epoch)
+			}
+			nodes = append(nodes, p)
+		}
+		resolved = append(resolved, nodes)
+	}
+	err = n.storeEpoch(c.Epoch)
+	if err != nil {
+		return 0, err
+	}
+	n.nodes, n.joining, n.leaving = resolved[0], resolved[1], resolved[2]
+	return n.epoch, nil
+}
+
+// Keys handles a request for the keys that the acceptor holds state for, as sent by the node that runs a membership change.
+func (n *Node) Keys() ([][]byte, error) {
+	return n.keys()
+}
+
+func containsNode(nodes []*Node, a *Node) bool {
+	for _, n := range nodes {
+		if n.ID == a.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutNode(nodes []*Node, a *Node) []*Node {
+	result := []*Node{}
+	for _, n := range nodes {
+		if n.ID != a.ID {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// AddAcceptor safely adds the acceptor a to the cluster, while the cluster keeps serving proposals.
+// a should be a fresh node with its own store and Transport. Every node in the cluster, and a, is sent the new configurations.
+// Every node has to know a, and a every node, beforehand; see AddPeer. The Transport of each of them has to implement ConfigTransport,
+// and the store of each acceptor KeyIterator; since every key is re-read through the new quorum.
+// Only one membership change should run at a time.
+// If AddAcceptor fails, the cluster is left in a transitional configuration that is safe to run, and calling AddAcceptor again completes the change.
+func (n *Node) AddAcceptor(ctx context.Context, a *Node) error {
+	n.configMu.RLock()
+	members := append([]*Node{}, n.nodes...)
+	n.configMu.RUnlock()
+	if containsNode(members, a) {
+		return fmt.Errorf("node:%v is already an acceptor of the cluster", a.ID)
+	}
+	if a.Trans == nil {
+		return fmt.Errorf("node:%v has no Transport", a.ID)
+	}
+	n.AddPeer(a)
+	proposers := removeDuplicatesNodes(append([]*Node{n, a}, members...))
+
+	// 1. accept messages go to the new acceptor as well.
+	err := n.sendConfig(ctx, proposers, members, []*Node{a}, nil)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to add acceptor:%v to the cluster", a.ID))
+	}
+
+	// 2. bring the new acceptor up to date.
+	err = n.catchUp(ctx, members)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to add acceptor:%v to the cluster", a.ID))
+	}
+
+	// 3. prepare messages go to the new acceptor as well.
+	newMembers := append(members, a)
+	err = n.sendConfig(ctx, proposers, newMembers, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to add acceptor:%v to the cluster", a.ID))
+	}
+	return nil
+}
+
+// RemoveAcceptor safely removes the acceptor a from the cluster, while the cluster keeps serving proposals.
+// Every node in the cluster, including a, is sent the new configurations.
+// The Transport of each of them has to implement ConfigTransport, and the store of each acceptor KeyIterator; since every key is re-read through the new quorum.
+// Only one membership change should run at a time.
+// If RemoveAcceptor fails, the cluster is left in a transitional configuration that is safe to run, and calling RemoveAcceptor again completes the change.
+func (n *Node) RemoveAcceptor(ctx context.Context, a *Node) error {
+	n.configMu.RLock()
+	members := append([]*Node{}, n.nodes...)
+	n.configMu.RUnlock()
+	if !containsNode(members, a) {
+		return fmt.Errorf("node:%v is not an acceptor of the cluster", a.ID)
+	}
+	newMembers := withoutNode(members, a)
+	if len(newMembers) < minimumNoAcceptors {
+		return fmt.Errorf("removing node:%v would leave the cluster with %v acceptors, less than the required minimum of:%v", a.ID, len(newMembers), minimumNoAcceptors)
+	}
+	proposers := removeDuplicatesNodes(append([]*Node{n, a}, members...))
+
+	// 1. prepare messages stop going to the acceptor.
+	err := n.sendConfig(ctx, proposers, members, nil, []*Node{a})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to remove acceptor:%v from the cluster", a.ID))
+	}
+
+	// 2. make sure every key is stored by a quorum of the remaining acceptors.
+	err = n.catchUp(ctx, members)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to remove acceptor:%v from the cluster", a.ID))
+	}
+
+	// 3. accept messages stop going to the acceptor.
+	err = n.sendConfig(ctx, proposers, newMembers, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to remove acceptor:%v from the cluster", a.ID))
+	}
+	return nil
+}
+
+// maxConfigAttempts is the number of times that sendEpoch picks a newer epoch, when some node already runs an epoch at least as new.
+const maxConfigAttempts = 5
+
+// sendConfig sends the configuration made up of members, joining and leaving to each of nodes, over their ConfigTransport.
+// The configuration gets an epoch that is newer than the one that any of them runs.
+func (n *Node) sendConfig(ctx context.Context, nodes []*Node, members, joining, leaving []*Node) error {
+	transports := map[uint64]ConfigTransport{}
+	for _, p := range nodes {
+		t, ok := p.Trans.(ConfigTransport)
+		if !ok {
+			return fmt.Errorf("the Transport of node:%v does not implement ConfigTransport", p.ID)
+		}
+		transports[p.ID] = t
+	}
+	c := Config{Nodes: nodeIDs(members), Joining: nodeIDs(joining), Leaving: nodeIDs(leaving)}
+	return n.sendEpoch(nodes, func(p *Node, epoch uint64) (uint64, error) {
+		c.Epoch = epoch
+		return transports[p.ID].TransportConfig(ctx, c)
+	})
+}
+
+// sendEpoch calls send for each of nodes with an epoch that is newer than the one that the node runs.
+// send returns the epoch that p runs afterwards; if any of nodes already ran an epoch at least as new, they are all sent a newer one.
+func (n *Node) sendEpoch(nodes []*Node, send func(p *Node, epoch uint64) (uint64, error)) error {
+	epoch, err := n.currentEpoch()
+	if err != nil {
+		return err
+	}
+	epoch++
+
+	for attempts := 0; attempts < maxConfigAttempts; attempts++ {
+		newest := epoch
+		for _, p := range nodes {
+			e, err := send(p, epoch)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("unable to send epoch:%v to node:%v", epoch, p.ID))
+			}
+			if e > newest {
+				newest = e
+			}
+		}
+		if newest == epoch {
+			return nil
+		}
+		// some node already runs a newer epoch, and has ignored this one.
+		epoch = newest + 1
+	}
+	return fmt.Errorf("unable to send an epoch newer than the ones the nodes run after %v attempt(s)", maxConfigAttempts)
+}
+
+func nodeIDs(nodes []*Node) []uint64 {
+	IDs := []uint64{}
+	for _, n := range nodes {
+		IDs = append(IDs, n.ID)
+	}
+	return IDs
+}
+
+// catchUp re-reads every key stored by the acceptors, by proposing the identity ChangeFunction,
+// so that it is written to a quorum of the node's current configuration.
+func (n *Node) catchUp(ctx context.Context, acceptors []*Node) error {
+	keys := map[string]bool{}
+	for _, a := range acceptors {
+		t, ok := a.Trans.(ConfigTransport)
+		if !ok {
+			return fmt.Errorf("the Transport of acceptor:%v does not implement ConfigTransport", a.ID)
+		}
+		acceptorKeys, err := t.TransportKeys(ctx)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to list the keys of acceptor:%v", a.ID))
+		}
+		for _, k := range acceptorKeys {
+			keys[string(k)] = true
+		}
+	}
+
+	policy := n.retryPolicy
+	if policy.MaxAttempts < catchUpRetryPolicy.MaxAttempts {
+		policy = catchUpRetryPolicy
+	}
+	for k := range keys {
+		key := []byte(k)
+		_, err := n.withRetries(ctx, policy, "catch up", func() ([]byte, error) {
+			// a full prepare and accept cycle; a Read could skip writing to the new acceptors.
+			ballot, currentState, err := n.sendPrepare(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			return n.sendAccept(ctx, key, ballot, currentState, identityFunc)
+		})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to catch up key:%v", key))
+		}
+	}
+	return nil
+}
+
+// keys returns the keys that the acceptor holds state for; including the keys that are still laid out as older versions of kshaka stored them.
+func (n *Node) keys() ([][]byte, error) {
+	data, ok := n.dataStore().(KeyIterator)
+	if !ok {
+		return nil, fmt.Errorf("the store of acceptor:%v does not implement KeyIterator", n.ID)
+	}
+	keys := [][]byte{}
+	seen := map[string]bool{}
+	err := data.IteratePrefix(nil, func(k []byte, val []byte) error {
+		if len(val) > 0 {
+			keys = append(keys, append([]byte{}, k...))
+			seen[string(k)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to list the keys of acceptor:%v", n.ID))
+	}
+
+	// the keys that are still stored as older versions of kshaka laid them out.
+	legacy, err := n.legacyKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range legacy {
+		if !seen[string(k)] {
+			keys = append(keys, k)
+			seen[string(k)] = true
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,192 @@
+package kshaka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newMembershipNode(ID uint64) *Node {
+	n := NewNode(ID, &InmemStore{})
+	n.AddTransport(&InmemTransport{Node: n})
+	n.AddRetryPolicy(RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond})
+	return n
+}
+
+// introduce lets a and each of nodes know of each other, as a node that runs in another process would be told.
+func introduce(a *Node, nodes ...*Node) {
+	for _, n := range nodes {
+		n.AddPeer(a)
+		a.AddPeer(n)
+	}
+}
+
+func TestTransitionalConfiguration(t *testing.T) {
+	n1, n2, n3, n4 := newMembershipNode(1), newMembershipNode(2), newMembershipNode(3), newMembershipNode(4)
+	members := []*Node{n1, n2, n3}
+	introduce(n1, n2, n3, n4)
+
+	tests := []struct {
+		name        string
+		joining     []*Node
+		leaving     []*Node
+		wantPrepare int
+		wantAccept  int
+	}{
+		{name: "stable", wantPrepare: 3, wantAccept: 3},
+		{name: "growing", joining: []*Node{n4}, wantPrepare: 3, wantAccept: 4},
+		{name: "shrinking", leaving: []*Node{n3}, wantPrepare: 2, wantAccept: 3},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{Epoch: uint64(i), Nodes: nodeIDs(members), Joining: nodeIDs(tt.joining), Leaving: nodeIDs(tt.leaving)}
+			if _, err := n1.ApplyConfig(c); err != nil {
+				t.Fatalf("\nn.ApplyConfig() \nerr = %v", err)
+			}
+			if got := len(n1.prepareAcceptors()); got != tt.wantPrepare {
+				t.Errorf("\nn.prepareAcceptors() \ngot= %v, \nwant = %v", got, tt.wantPrepare)
+			}
+			if got := len(n1.acceptAcceptors()); got != tt.wantAccept {
+				t.Errorf("\nn.acceptAcceptors() \ngot= %v, \nwant = %v", got, tt.wantAccept)
+			}
+		})
+	}
+}
+
+func TestAddRemoveAcceptor(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+	ctx := context.Background()
+
+	nodes := []*Node{newMembershipNode(1), newMembershipNode(2), newMembershipNode(3)}
+	MingleNodes(nodes...)
+	want := map[string][]byte{}
+	for i := 0; i < 5; i++ {
+		key, val := fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("val-%d", i))
+		if _, err := nodes[0].Propose([]byte(key), setFunc(val)); err != nil {
+			t.Fatalf("\nnode.Propose() \nerr = %v", err)
+		}
+		want[key] = val
+	}
+
+	// grow from 3 to 5 acceptors.
+	for _, ID := range []uint64{4, 5} {
+		a := newMembershipNode(ID)
+		introduce(a, nodes...)
+		if err := nodes[0].AddAcceptor(ctx, a); err != nil {
+			t.Fatalf("\nnode.AddAcceptor(%v) \nerr = %v", ID, err)
+		}
+		nodes = append(nodes, a)
+		for k, val := range want {
+			// the new acceptor, as a proposer, sees every key.
+			got, err := a.Read([]byte(k))
+			if err != nil {
+				t.Fatalf("\nnode.Read(%v) \nerr = %v", k, err)
+			}
+			if !reflect.DeepEqual(got, val) {
+				t.Errorf("\nnode:%v key:%v \ngot= %s, \nwant = %s", ID, k, got, val)
+			}
+		}
+	}
+	for _, n := range nodes {
+		if got := len(n.prepareAcceptors()); got != 5 {
+			t.Errorf("\nnode:%v prepareAcceptors \ngot= %v, \nwant = %v", n.ID, got, 5)
+		}
+		if got := len(n.acceptAcceptors()); got != 5 {
+			t.Errorf("\nnode:%v acceptAcceptors \ngot= %v, \nwant = %v", n.ID, got, 5)
+		}
+	}
+
+	// the new acceptors can propose, and serve, the keys.
+	if _, err := nodes[4].Propose([]byte("key-0"), setFunc([]byte("new-val-0"))); err != nil {
+		t.Fatalf("\nnode.Propose() \nerr = %v", err)
+	}
+	want["key-0"] = []byte("new-val-0")
+
+	// shrink back to 3 acceptors, dropping the original ones.
+	for _, a := range nodes[:2] {
+		if err := nodes[4].RemoveAcceptor(ctx, a); err != nil {
+			t.Fatalf("\nnode.RemoveAcceptor(%v) \nerr = %v", a.ID, err)
+		}
+	}
+	if err := nodes[4].RemoveAcceptor(ctx, nodes[2]); err == nil {
+		t.Errorf("\nnode.RemoveAcceptor(%v) \nwanted an error since only %v acceptors would remain", nodes[2].ID, 2)
+	}
+	for k, val := range want {
+		got, err := nodes[3].Read([]byte(k))
+		if err != nil {
+			t.Fatalf("\nnode.Read(%v) \nerr = %v", k, err)
+		}
+		if !reflect.DeepEqual(got, val) {
+			t.Errorf("\nnode.Read(%v) \ngot= %s, \nwant = %s", k, got, val)
+		}
+	}
+}
+
+func TestOutdatedProposerRejected(t *testing.T) {
+	nodes := []*Node{newMembershipNode(1), newMembershipNode(2), newMembershipNode(3)}
+	MingleNodes(nodes...)
+
+	// a proposer that is not part of the cluster, and thus is not told about membership changes.
+	outdated := newMembershipNode(9)
+	outdated.nodes = nodes
+	if _, err := outdated.Propose([]byte("foo"), identityFunc); err != nil {
+		t.Fatalf("\noutdated.Propose() \nerr = %v", err)
+	}
+
+	a := newMembershipNode(4)
+	introduce(a, nodes...)
+	if err := nodes[0].AddAcceptor(context.Background(), a); err != nil {
+		t.Fatalf("\nnode.AddAcceptor() \nerr = %v", err)
+	}
+	if _, err := outdated.Propose([]byte("foo"), identityFunc); err == nil {
+		t.Errorf("\noutdated.Propose() \nwanted an error since the proposer runs an outdated configuration")
+	}
+	if _, err := nodes[1].Propose([]byte("foo"), identityFunc); err != nil {
+		t.Errorf("\nnode.Propose() \nerr = %v", err)
+	}
+}
+
+func TestUnknownAcceptorRejected(t *testing.T) {
+	nodes := []*Node{newMembershipNode(1), newMembershipNode(2), newMembershipNode(3)}
+	MingleNodes(nodes...)
+
+	// only the node that runs the change knows of the new acceptor.
+	a := newMembershipNode(4)
+	a.AddPeer(nodes[0])
+	if err := nodes[0].AddAcceptor(context.Background(), a); err == nil {
+		t.Errorf("\nnode.AddAcceptor() \nwanted an error since the other nodes do not know of the new acceptor")
+	}
+	if got := len(nodes[1].acceptAcceptors()); got != 3 {
+		t.Errorf("\nnode.acceptAcceptors() \ngot= %v, \nwant = %v", got, 3)
+	}
+}
+
+func TestEpochPersisted(t *testing.T) {
+	store := &InmemStore{}
+	n := NewNode(1, store)
+	if err := n.setEpoch(7); err != nil {
+		t.Fatalf("\nn.setEpoch() \nerr = %v", err)
+	}
+
+	// the restarted acceptor still rejects Ballots from older configurations.
+	restarted := NewNode(1, store)
+	epoch, err := restarted.currentEpoch()
+	if err != nil {
+		t.Fatalf("\nn.currentEpoch() \nerr = %v", err)
+	}
+	if epoch != 7 {
+		t.Errorf("\nn.currentEpoch() \ngot= %v, \nwant = %v", epoch, 7)
+	}
+	_, err = restarted.Prepare(Ballot{Counter: 100, NodeID: 2, Epoch: 6}, []byte("foo"))
+	var conflict *ErrConflict
+	if !errors.As(err, &conflict) {
+		t.Errorf("\nn.Prepare() \ngot err = %v, \nwanted an *ErrConflict", err)
+	}
+}
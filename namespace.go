@@ -0,0 +1,121 @@
+package kshaka
+
+import (
+	"bytes"
+	"fmt"
+)
+
+/*
+A node keeps the acceptor state of the keys that clients propose, and the metadata that it keeps for itself(eg its Ballot counter),
+in two separate namespaces of its store; data and meta. Keys of one namespace can never collide with, or read, the keys of the other.
+Stores that implement BucketStore keep each namespace in a bucket of its own,
+for the other stores the keys of each namespace are prefixed with the name of the namespace.
+*/
+
+// DataNamespace is the name of the namespace that holds the acceptor state of the keys that clients propose.
+// The value of each of its keys is an AcceptorState encoded with AcceptorState.MarshalBinary,
+// so a BucketStore can store the Ballots and state of its Bucket(DataNamespace) in a layout of its own.
+const DataNamespace = "data"
+
+var (
+	dataNamespace = []byte(DataNamespace)
+	metaNamespace = []byte("meta")
+)
+
+// namespacePrefix is the prefix of the keys of a namespace, in a store that does not implement BucketStore.
+// It is not printable, so that it is unlikely to start any of the keys that older versions of kshaka left in the store.
+func namespacePrefix(name []byte) []byte {
+	return []byte(fmt.Sprintf("\x00kshaka\x00%s\x00", name))
+}
+
+// BucketStore is an optional interface that a StableStore can implement to keep the namespaces of a node in separate buckets,
+// rather than under key prefixes in its own keyspace.
+type BucketStore interface {
+	// Bucket returns the store of the bucket called name; each bucket is a keyspace of its own.
+	// Bucket is called once per namespace, when the node first uses its store.
+	// Like the store itself, a bucket can implement KeyIterator and DeleteStore.
+	Bucket(name []byte) StableStore
+}
+
+// NamespacedStore is a StableStore that keeps its keys under Prefix in Store, apart from the other keys of Store.
+// It can be used to let several nodes share one store, each with a different Prefix.
+// NamespacedStore implements KeyIterator if Store does, and DeleteStore; overwriting keys with an empty value if Store does not implement it.
+type NamespacedStore struct {
+	Store  StableStore
+	Prefix []byte
+}
+
+func (ns *NamespacedStore) key(key []byte) []byte {
+	k := make([]byte, 0, len(ns.Prefix)+len(key))
+	k = append(k, ns.Prefix...)
+	return append(k, key...)
+}
+
+// Set implements the StableStore interface.
+func (ns *NamespacedStore) Set(key []byte, val []byte) error {
+	return ns.Store.Set(ns.key(key), val)
+}
+
+// Get implements the StableStore interface.
+func (ns *NamespacedStore) Get(key []byte) ([]byte, error) {
+	return ns.Store.Get(ns.key(key))
+}
+
+// SetUint64 implements the StableStore interface.
+func (ns *NamespacedStore) SetUint64(key []byte, val uint64) error {
+	return ns.Store.SetUint64(ns.key(key), val)
+}
+
+// GetUint64 implements the StableStore interface.
+func (ns *NamespacedStore) GetUint64(key []byte) (uint64, error) {
+	return ns.Store.GetUint64(ns.key(key))
+}
+
+// Delete implements the DeleteStore interface.
+func (ns *NamespacedStore) Delete(key []byte) error {
+	return removeKey(ns.Store, ns.key(key))
+}
+
+// IteratePrefix implements the KeyIterator interface.
+// It returns an error if Store does not implement KeyIterator.
+func (ns *NamespacedStore) IteratePrefix(prefix []byte, fn func(key []byte, val []byte) error) error {
+	iterator, ok := ns.Store.(KeyIterator)
+	if !ok {
+		return fmt.Errorf("the store under prefix:%q does not implement KeyIterator", ns.Prefix)
+	}
+	return iterator.IteratePrefix(ns.key(prefix), func(key []byte, val []byte) error {
+		return fn(bytes.TrimPrefix(key, ns.Prefix), val)
+	})
+}
+
+// namespace returns the namespace called name of store, and its TxnStore; which is nil if store does not implement TxnStore.
+func namespace(store StableStore, name []byte) (StableStore, TxnStore) {
+	if b, ok := store.(BucketStore); ok {
+		bucket := b.Bucket(name)
+		txns, _ := bucket.(TxnStore)
+		return bucket, txns
+	}
+	ns := &NamespacedStore{Store: store, Prefix: namespacePrefix(name)}
+	if txns, ok := store.(TxnStore); ok {
+		return ns, &namespacedTxnStore{store: txns, ns: ns}
+	}
+	return ns, nil
+}
+
+// openNamespaces sets up the data and meta namespaces of the node's store.
+func (n *Node) openNamespaces() {
+	n.dataNamespace, n.dataTxns = namespace(n.acceptorStore, dataNamespace)
+	n.metaNamespace, _ = namespace(n.acceptorStore, metaNamespace)
+}
+
+// dataStore returns the namespace that holds the acceptor state of the keys that clients propose.
+func (n *Node) dataStore() StableStore {
+	n.namespacesOnce.Do(n.openNamespaces)
+	return n.dataNamespace
+}
+
+// metaStore returns the namespace that holds the metadata that the node keeps for itself.
+func (n *Node) metaStore() StableStore {
+	n.namespacesOnce.Do(n.openNamespaces)
+	return n.metaNamespace
+}
@@ -0,0 +1,177 @@
+package kshaka
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// bucketStore is an InmemStore that keeps each bucket in an InmemStore of its own.
+type bucketStore struct {
+	InmemStore
+	l       sync.Mutex
+	buckets map[string]*InmemStore
+}
+
+func (b *bucketStore) Bucket(name []byte) StableStore {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.buckets == nil {
+		b.buckets = map[string]*InmemStore{}
+	}
+	if _, ok := b.buckets[string(name)]; !ok {
+		b.buckets[string(name)] = &InmemStore{}
+	}
+	return b.buckets[string(name)]
+}
+
+func TestNamespacedStore(t *testing.T) {
+	store := &InmemStore{}
+	a := &NamespacedStore{Store: store, Prefix: []byte("a/")}
+	b := &NamespacedStore{Store: store, Prefix: []byte("b/")}
+	key := []byte("foo")
+
+	if err := a.Set(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("\nNamespacedStore.Get() \ngot= %v, \nwant = %v", err, ErrNotFound)
+	}
+	val, err := store.Get([]byte("a/foo"))
+	if err != nil || !bytes.Equal(val, []byte("bar")) {
+		t.Errorf("\nstore.Get() \ngot= %s %v, \nwant = %s", val, err, "bar")
+	}
+
+	keys := []string{}
+	err = a.IteratePrefix(nil, func(k []byte, val []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"foo"}) {
+		t.Errorf("\nNamespacedStore.IteratePrefix() \ngot= %v, \nwant = %v", keys, []string{"foo"})
+	}
+
+	if err := a.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("\nNamespacedStore.Get() \ngot= %v, \nwant = %v", err, ErrNotFound)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+
+	t.Run("client keys cannot reach the metadata", func(t *testing.T) {
+		stores := []*InmemStore{}
+		nodes := []*Node{}
+		for i := uint64(1); i <= 3; i++ {
+			store := &InmemStore{}
+			n := NewNode(i, store)
+			n.AddTransport(&InmemTransport{Node: n})
+			stores = append(stores, store)
+			nodes = append(nodes, n)
+		}
+		MingleNodes(nodes...)
+		for _, key := range [][]byte{ballotCounterMetaKey, append(namespacePrefix(metaNamespace), ballotCounterMetaKey...)} {
+			if _, err := nodes[0].Propose(key, setFunc([]byte("bar"))); err != nil {
+				t.Fatalf("\nnode.Propose(%q) \nerr = %v", key, err)
+			}
+		}
+
+		restarted := NewNode(1, stores[0])
+		b, err := restarted.incBallot(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !nodes[0].Ballot.Less(b) {
+			t.Errorf("\nrestarted node reused Ballot \ngot = %#+v, \nwanted greater than = %#+v", b, nodes[0].Ballot)
+		}
+	})
+
+	t.Run("buckets", func(t *testing.T) {
+		stores := []*bucketStore{}
+		nodes := []*Node{}
+		for i := uint64(1); i <= 3; i++ {
+			store := &bucketStore{}
+			n := NewNode(i, store)
+			n.AddTransport(&InmemTransport{Node: n})
+			stores = append(stores, store)
+			nodes = append(nodes, n)
+		}
+		MingleNodes(nodes...)
+		key := []byte("foo")
+		if _, err := nodes[0].Propose(key, setFunc([]byte("bar"))); err != nil {
+			t.Fatalf("\nnode.Propose() \nerr = %v", err)
+		}
+		for i, s := range stores {
+			if len(s.kv) != 0 || len(s.kvint) != 0 {
+				t.Errorf("\nacceptor:%v wrote outside of its buckets \ngot= %v %v", i+1, s.kv, s.kvint)
+			}
+			if _, err := s.Bucket(dataNamespace).Get(key); err != nil {
+				t.Errorf("\nacceptor:%v data bucket \nerr = %v", i+1, err)
+			}
+		}
+	})
+}
+
+func TestNamespacesMigration(t *testing.T) {
+	store := &InmemStore{}
+	key := []byte("foo")
+	want := AcceptorState{AcceptedBallot: Ballot{Counter: 7, NodeID: 2}, State: []byte("bar")}
+
+	// a store laid out by the version that kept acceptor state records and the Ballot counter alongside the client's keys.
+	n := NewNode(1, &InmemStore{})
+	if err := n.setAcceptorState(&storeTxn{store: n.dataStore()}, key, want, false); err != nil {
+		t.Fatal(err)
+	}
+	record, err := n.dataStore().Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(acceptorStateKey(key), record); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetUint64(ballotCounterKey, 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	n = NewNode(1, store)
+	if n.Ballot.Counter != 5000 {
+		t.Errorf("\nBallot counter \ngot = %#+v, \nwanted = %#+v", n.Ballot.Counter, 5000)
+	}
+	keys, err := n.keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, [][]byte{key}) {
+		t.Errorf("\nn.keys() \ngot = %q, \nwanted = %q", keys, [][]byte{key})
+	}
+	acceptorState, err := n.getAcceptorState(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(acceptorState, want) {
+		t.Errorf("\nn.getAcceptorState() \ngot = %#+v, \nwanted = %#+v", acceptorState, want)
+	}
+
+	// once written, the key is moved to the data namespace.
+	if _, err := n.Prepare(Ballot{Counter: 8, NodeID: 1}, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(acceptorStateKey(key)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("\nstore.Get(acceptorStateKey) \ngot = %v, \nwanted = %v", err, ErrNotFound)
+	}
+	if _, err := n.dataStore().Get(key); err != nil {
+		t.Errorf("\nn.dataStore().Get() \nerr = %v", err)
+	}
+}
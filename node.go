@@ -20,11 +20,13 @@ Example usage:
 
 	func main() {
 		// The store should, ideally be disk persisted.
-		// Any that implements hashicorp/raft StableStore interface will suffice
+		// Any that implements the kshaka.StableStore interface will suffice; those of hashicorp/raft have to be
+		// wrapped in a kshaka.RaftStore so that missing keys are reported as kshaka.ErrNotFound
 		boltStore, err := raftboltdb.NewBoltStore("/tmp/bolt.db")
 		if err != nil {
 			panic(err)
 		}
+		store := &kshaka.RaftStore{Store: boltStore}
 
 		// The function that will be applied by CASPaxos.
 		// This will be applied to the current value stored
@@ -37,9 +39,9 @@ Example usage:
 
 		// Note that, in practice, nodes ideally should be
 		// in different machines each with its own store.
-		node1 := kshaka.NewNode(1, boltStore)
-		node2 := kshaka.NewNode(2, boltStore)
-		node3 := kshaka.NewNode(3, boltStore)
+		node1 := kshaka.NewNode(1, store)
+		node2 := kshaka.NewNode(2, store)
+		node3 := kshaka.NewNode(3, store)
 
 		transport1 := &kshaka.InmemTransport{Node: node1}
 		transport2 := &kshaka.InmemTransport{Node: node2}
@@ -61,22 +63,20 @@ Example usage:
 		fmt.Printf("\n newstate: %v \n", newstate)
 	}
 
-
 TODO: add system design here.
 */
 package kshaka
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-const stableStoreNotFoundErr = "not found"
-
 // Node satisfies the ProposerAcceptor interface.
 // A Node is both a proposer and an acceptor. Most people will be interacting with a Node instead of a Proposer/Acceptor.
 // note: the fields; acceptorStore, Trans and nodes should not be nil/default values
@@ -84,25 +84,98 @@ type Node struct {
 	// ID should be unique to each node in the cluster.
 	ID       uint64
 	Metadata map[string]string
-	Ballot   Ballot
-	nodes    []*Node
+	// Ballot is the last Ballot that the node issued as a proposer.
+	// It may be set before the node starts proposing, but is only safe to read or write through the node after that.
+	Ballot Ballot
+	// ballotMu protects Ballot, keyBallots, ballotLease and ballotLeaseLoaded; so that concurrent proposals never share a Ballot.
+	ballotMu sync.Mutex
+	// keyBallots holds the Ballot counter of each key, if the node keeps one per key. See EnablePerKeyBallots
+	keyBallots *keyBallots
+
+	// nodes are the acceptors of the cluster, as known by this node.
+	// joining and leaving are acceptors that a membership change in progress is adding to, or removing from, the cluster.
+	// accept messages are sent to nodes and joining, prepare messages are sent to nodes that are not leaving.
+	// epoch is the version of that configuration, acceptors reject Ballots from proposers running an older configuration.
+	// It is persisted in the meta namespace, epochLoaded reports whether it has been restored from there.
+	// peers are the other nodes that the node knows of, and may be sent a configuration with; see AddPeer
+	// configMu protects all of them.
+	nodes       []*Node
+	joining     []*Node
+	leaving     []*Node
+	epoch       uint64
+	epochLoaded bool
+	peers       map[uint64]*Node
+	configMu    sync.RWMutex
 
 	// In general the "prepare" and "accept" operations affecting the same key should be mutually exclusive.
 	// How to achieve this is an implementation detail.
 	// eg in Gryadka it doesn't matter because the operations are implemented as Redis's stored procedures and Redis is single threaded. - Denis Rystsov
-	// the mux protects the state(acceptorStore)
-	sync.Mutex
+	// keyLocks protects the state(acceptorStore) of each key, operations on different keys do not block each other.
+	keyLocks keyLocker
 
 	// acceptorStore is a StableStore implementation for durable state
 	// It provides stable storage for many fields in raftState
+	// It is accessed concurrently for different keys and thus has to be safe for concurrent use.
 	acceptorStore StableStore
+	// dataNamespace and metaNamespace are the namespaces of acceptorStore; see dataStore and metaStore.
+	namespacesOnce sync.Once
+	dataNamespace  StableStore
+	metaNamespace  StableStore
+	// dataTxns is nil unless acceptorStore implements TxnStore; see beginData.
+	dataTxns TxnStore
 
 	Trans Transport
+
+	retryPolicy RetryPolicy
+
+	// quorumSystem decides how many confirmations the node waits for in each phase, nil means a Majority.
+	quorumSystem QuorumSystem
+	// latencies is the round trip time to each acceptor, as measured by this node while proposing.
+	// fastestQuorums makes the node send its messages to the fastest quorum of acceptors first; see EnableFastestQuorums
+	latencies      latencies
+	fastestQuorums bool
+
+	// serializeKeys makes the proposals, reads and deletes that the node runs on the same key wait for each other.
+	// proposerKeyLocks is separate from keyLocks, which the node uses as an acceptor.
+	serializeKeys    bool
+	proposerKeyLocks keyLocker
+
+	// combining makes the node run the concurrent proposals on each key as batches; combiners holds the queued proposals of each key.
+	combining   bool
+	combinersMu sync.Mutex
+	combiners   map[string]*combiner
+
+	// oneRoundTrip enables piggybacking the prepare message of the next proposal on a key onto the accept message of the current one.
+	// prepared holds the Ballots that acceptors have promised for the next proposal on each key, for the most recently used keys.
+	oneRoundTrip bool
+	preparedMu   sync.Mutex
+	prepared     *lru
+
+	// ballotLease is the Ballot counter up to which the node has reserved counters in its acceptorStore.
+	// Any counter below it may already have been issued, so a restarted node resumes from it.
+	// Reserving counters in batches saves each proposal from having to write to the store.
+	ballotLease       uint64
+	ballotLeaseLoaded bool
+
+	// legacyMigrated is set once no key is left laid out as older versions of kshaka stored them; see migrateLegacyState.
+	// It is only written by NewNode.
+	legacyMigrated bool
 }
 
 // NewNode creates a new node.
+// The node restores the Ballot counter and configuration epoch that it persisted in store before it was restarted.
+// Keys that are still laid out as older versions of kshaka stored them are migrated, if store implements KeyIterator.
 func NewNode(ID uint64, store StableStore) *Node {
-	n := &Node{ID: ID, acceptorStore: store}
+	n := &Node{ID: ID, acceptorStore: store, Ballot: Ballot{NodeID: ID}}
+	// if these fail, incBallot and currentEpoch will try again and report the error.
+	n.ballotMu.Lock()
+	_ = n.loadBallotCounter()
+	n.ballotMu.Unlock()
+	n.configMu.Lock()
+	_ = n.loadEpoch()
+	n.configMu.Unlock()
+	// if this fails, each key is migrated the next time it is written.
+	_ = n.migrateLegacyState()
 	return n
 }
 
@@ -119,12 +192,15 @@ func removeDuplicatesNodes(a []*Node) []*Node {
 }
 
 // MingleNodes lets each node know about the other, including itself.
+// It is meant for bootstrapping a cluster, use Node.AddAcceptor and Node.RemoveAcceptor to change the membership of a running cluster.
 func MingleNodes(nodes ...*Node) {
 	for _, n := range nodes {
+		n.configMu.Lock()
 		incomingNodes := nodes
 		unDedupedNodes := append(incomingNodes, n.nodes...)
 		dedupedNodes := removeDuplicatesNodes(unDedupedNodes)
 		n.nodes = dedupedNodes
+		n.configMu.Unlock()
 	}
 }
 
@@ -134,13 +210,118 @@ func (n *Node) AddTransport(t Transport) {
 }
 
 // AddMetadata adds metadata to a node. eg name=myNode, env=production
+// The zone label, eg zone=us-east-1a, is used by ZoneAware quorums.
 func (n *Node) AddMetadata(metadata map[string]string) {
 	n.Metadata = metadata
 }
 
-// monotonically increase the Ballot
-func (n *Node) incBallot() {
-	n.Ballot.Counter++
+// AddRetryPolicy sets the policy that the node uses to retry proposals that fail due to conflicts.
+func (n *Node) AddRetryPolicy(p RetryPolicy) {
+	n.retryPolicy = p
+}
+
+// AddQuorumSystem sets the QuorumSystem that the node uses to decide when it has enough confirmations from the acceptors.
+// Every proposer in the cluster should use QuorumSystems that agree with each other; eg the same Flexible quorum sizes.
+func (n *Node) AddQuorumSystem(q QuorumSystem) {
+	n.quorumSystem = q
+}
+
+// quorum returns the QuorumSystem of the node, and validates it against the acceptors that the node currently knows of.
+func (n *Node) quorum() (QuorumSystem, error) {
+	q := n.quorumSystem
+	if q == nil {
+		q = Majority{}
+	}
+	err := q.Validate(n.prepareAcceptors(), n.acceptAcceptors())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid quorum system")
+	}
+	return q, nil
+}
+
+// loadBallotCounter restores the Ballot counter from the node's store.
+// ballotMu must be held.
+func (n *Node) loadBallotCounter() error {
+	lease, err := n.metaStore().GetUint64(ballotCounterMetaKey)
+	if errors.Is(err, ErrNotFound) {
+		lease, err = 0, nil
+	}
+	if err == nil && lease == 0 {
+		// the counter may still be where older versions of kshaka kept it, the next reservation moves it to the meta namespace.
+		lease, err = n.acceptorStore.GetUint64(ballotCounterKey)
+		if errors.Is(err, ErrNotFound) {
+			lease, err = 0, nil
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to get the Ballot counter of node:%v", n.ID))
+	}
+	if n.Ballot.Counter < lease {
+		n.Ballot.Counter = lease
+	}
+	n.ballotLease = lease
+	n.ballotLeaseLoaded = true
+	return nil
+}
+
+// monotonically increase the Ballot of key, and return the new Ballot.
+// Unless the node keeps per key Ballots, all keys share one Ballot.
+// Each call returns a different Ballot, even when called concurrently.
+// The counter is persisted in batches of ballotCounterLease, the store is only written to when a batch is used up.
+func (n *Node) incBallot(key []byte) (Ballot, error) {
+	n.ballotMu.Lock()
+	defer n.ballotMu.Unlock()
+	if !n.ballotLeaseLoaded {
+		err := n.loadBallotCounter()
+		if err != nil {
+			return Ballot{}, err
+		}
+	}
+
+	counter := n.keyCounter(key) + 1
+	if counter >= n.ballotLease {
+		lease := counter + ballotCounterLease
+		err := n.metaStore().SetUint64(ballotCounterMetaKey, lease)
+		if err != nil {
+			return Ballot{}, errors.Wrap(err, fmt.Sprintf("unable to persist the Ballot counter of node:%v", n.ID))
+		}
+		n.ballotLease = lease
+	}
+	epoch, err := n.currentEpoch()
+	if err != nil {
+		return Ballot{}, err
+	}
+	n.setKeyCounter(key, counter)
+	n.Ballot.NodeID = n.ID
+	b := Ballot{Counter: counter, NodeID: n.ID, Epoch: epoch}
+	if n.keyBallots == nil {
+		n.Ballot = b
+	}
+	return b, nil
+}
+
+// fastForward moves the Ballot of key forward, if need be, so that the next Ballot the node generates for key is greater than b.
+func (n *Node) fastForward(key []byte, b Ballot) {
+	n.ballotMu.Lock()
+	defer n.ballotMu.Unlock()
+	if n.keyCounter(key) < b.Counter {
+		n.setKeyCounter(key, b.Counter)
+	}
+}
+
+// SerializeKeyProposals makes the proposals, reads and deletes that the node runs on the same key wait for each other, instead of running concurrently.
+// Concurrent proposals on a key from the same node are safe without it, but they conflict with each other and have to be retried.
+// Waiting for an earlier proposal on the key is not bound by ctx.
+func (n *Node) SerializeKeyProposals() {
+	n.serializeKeys = true
+}
+
+// lockProposals takes the proposer lock of key if the node serializes its proposals per key, and returns the func that releases it.
+func (n *Node) lockProposals(key []byte) func() {
+	if !n.serializeKeys {
+		return func() {}
+	}
+	return n.proposerKeyLocks.lock(key)
 }
 
 // Propose is the method that clients call when they want to submit
@@ -148,8 +329,81 @@ func (n *Node) incBallot() {
 // It takes the key whose value you want to apply the ChangeFunction to
 // and also the ChangeFunction that will be applied to the value(contents) of that key.
 func (n *Node) Propose(key []byte, changeFunc ChangeFunction) ([]byte, error) {
+	return n.ProposeContext(context.Background(), key, changeFunc)
+}
+
+// ProposeContext is like Propose but it is bound by ctx.
+// If ctx is cancelled or its deadline expires before the prepare and accept phases complete,
+// ProposeContext stops waiting for the acceptors and returns ctx.Err().
+// The cancellation is also propagated to the Transport calls that are still in flight.
+// Proposals that fail due to conflicts are retried as configured by the node's RetryPolicy.
+// If the node combines requests, the proposal may be batched with others on key; see EnableRequestCombining
+func (n *Node) ProposeContext(ctx context.Context, key []byte, changeFunc ChangeFunction) ([]byte, error) {
+	if n.combining {
+		return n.proposeCombined(ctx, key, changeFunc)
+	}
+	unlock := n.lockProposals(key)
+	defer unlock()
+	return n.withRetries(ctx, n.retryPolicy, "proposal", func() ([]byte, error) {
+		return n.propose(ctx, key, changeFunc)
+	})
+}
+
+// withRetries calls op, and calls it again after each conflict as configured by policy.
+// A quorum that was not reached only because messages failed to be delivered is not retried.
+func (n *Node) withRetries(ctx context.Context, policy RetryPolicy, opName string, op func() ([]byte, error)) ([]byte, error) {
+	var (
+		attempts           int
+		backedOff          time.Duration
+		highBallotConflict Ballot
+	)
+	for {
+		attempts++
+		newState, err := op()
+		quorumErr, ok := err.(*ErrQuorumNotReached)
+		if !ok || !quorumErr.conflicted() {
+			return newState, err
+		}
+		if highBallotConflict.Less(quorumErr.Ballot) {
+			highBallotConflict = quorumErr.Ballot
+		}
+
+		if attempts >= policy.MaxAttempts {
+			return nil, errors.Wrap(err, fmt.Sprintf("%v failed after %v attempt(s), highest conflicting Ballot:%v", opName, attempts, highBallotConflict))
+		}
+		backoff := policy.backoff(attempts)
+		if policy.Budget > 0 && backedOff+backoff > policy.Budget {
+			return nil, errors.Wrap(err, fmt.Sprintf("%v failed after %v attempt(s), retry budget:%v exhausted, highest conflicting Ballot:%v", opName, attempts, policy.Budget, highBallotConflict))
+		}
+		backedOff = backedOff + backoff
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// propose runs a single prepare and accept cycle.
+func (n *Node) propose(ctx context.Context, key []byte, changeFunc ChangeFunction) ([]byte, error) {
+	oneRoundTrip := n.canOneRoundTrip()
+	if oneRoundTrip {
+		prepared, ok := n.takePrepared(key)
+		if ok {
+			// the acceptors already promised us a Ballot for this key; skip the prepare phase.
+			newState, err := n.sendAcceptPrepare(ctx, key, prepared.ballot, prepared.state, changeFunc)
+			if _, ok := err.(*ErrQuorumNotReached); !ok {
+				return newState, err
+			}
+			// fall back to two phases.
+		}
+	}
+
 	// prepare phase
-	currentState, err := n.sendPrepare(key)
+	ballot, currentState, err := n.sendPrepare(ctx, key)
 	if err != nil {
 		fmt.Printf("error: %+v\n", err)
 		return nil, err
@@ -157,7 +411,12 @@ func (n *Node) Propose(key []byte, changeFunc ChangeFunction) ([]byte, error) {
 	fmt.Printf("currentState: %+v %+v\n", currentState, string(currentState))
 
 	// accept phase
-	newState, err := n.sendAccept(key, currentState, changeFunc)
+	var newState []byte
+	if oneRoundTrip {
+		newState, err = n.sendAcceptPrepare(ctx, key, ballot, currentState, changeFunc)
+	} else {
+		newState, err = n.sendAccept(ctx, key, ballot, currentState, changeFunc)
+	}
 	if err != nil {
 		fmt.Printf("error: %+v\n", err)
 		return nil, err
@@ -168,78 +427,128 @@ func (n *Node) Propose(key []byte, changeFunc ChangeFunction) ([]byte, error) {
 }
 
 // The proposer generates a Ballot number, B, and sends ”prepare” messages containing that number(and it's ID) to the acceptors.
-// Proposer waits for the F + 1 confirmations.
+// Proposer waits for a quorum of confirmations, as decided by the node's QuorumSystem; F + 1 confirmations by default.
 // If all replies from acceptors contain the empty value, then the proposer defines the current state as ∅
 // otherwise it picks the value of the tuple with the highest Ballot number.
-func (n *Node) sendPrepare(key []byte) ([]byte, error) {
+// The Ballot B is returned, for the accept phase.
+func (n *Node) sendPrepare(ctx context.Context, key []byte) (Ballot, []byte, error) {
+	ballot, currentState, _, err := n.sendPrepareAgreement(ctx, key)
+	return ballot, currentState, err
+}
+
+// sendPrepareAgreement is like sendPrepare but also reports whether all the confirmations
+// that made up the quorum carried the same accepted Ballot.
+// Agreement only means that the value is the current one if the QuorumSystem's prepare quorums intersect; see read.
+func (n *Node) sendPrepareAgreement(ctx context.Context, key []byte) (Ballot, []byte, bool, error) {
 	var (
-		noAcceptors         = len(n.nodes)
-		F                   = (noAcceptors - 1) / 2 // number of failures we can tolerate
-		confirmationsNeeded = F + 1
-		highBallotConfirm   Ballot
-		highBallotConflict  = n.Ballot
-		currentState        []byte
-		numberConflicts     int
-		numberConfirmations int
+		acceptors          = n.prepareAcceptors()
+		noAcceptors        = len(acceptors)
+		confirmed          = []*Node{}
+		highBallotConfirm  Ballot
+		highBallotConflict Ballot
+		currentState       []byte
+		agreement          = true
+		causes             = map[uint64]error{}
 	)
 
 	if noAcceptors < minimumNoAcceptors {
-		return nil, fmt.Errorf("number of acceptors:%v is less than required minimum of:%v", noAcceptors, minimumNoAcceptors)
+		return Ballot{}, nil, false, &ErrTooFewAcceptors{Acceptors: noAcceptors, Minimum: minimumNoAcceptors}
+	}
+	quorum, err := n.quorum()
+	if err != nil {
+		return Ballot{}, nil, false, err
 	}
-	if bytes.Equal(key, acceptedBallotKey(key)) {
-		return nil, fmt.Errorf("the key:%v is reserved for storing kshaka internal state. chose another key", acceptedBallotKey(key))
+	if isReservedKey(key) {
+		return Ballot{}, nil, false, &ErrReservedKey{Key: key}
 	}
 
-	n.incBallot()
+	// the acceptors may still be replying after we have returned, and other proposals may be running concurrently;
+	// so everyone gets a copy of the Ballot.
+	ballot, err := n.incBallot(key)
+	if err != nil {
+		return Ballot{}, nil, false, err
+	}
+	highBallotConflict = ballot
 	type prepareResult struct {
+		acceptor      *Node
 		acceptedState AcceptorState
 		err           error
 	}
 
 	prepareResultChan := make(chan prepareResult, noAcceptors)
-	for _, a := range n.nodes {
-		go func(a *Node) {
-			acceptedState, err := a.Trans.TransportPrepare(n.Ballot, key)
-			prepareResultChan <- prepareResult{acceptedState, err}
-		}(a)
-	}
-
-	for i := 0; i < cap(prepareResultChan) && confirmationsNeeded > 0; i++ {
-		res := <-prepareResultChan
+	fan := n.startFanOut(PreparePhase, quorum, acceptors, func(a *Node) {
+		go func() {
+			start := time.Now()
+			acceptedState, err := a.Trans.TransportPrepare(ctx, ballot, key)
+			if ctx.Err() == nil {
+				n.latencies.observe(a.ID, time.Since(start))
+			}
+			prepareResultChan <- prepareResult{a, acceptedState, err}
+		}()
+	})
+	defer fan.stop()
+
+	for !quorum.IsQuorum(PreparePhase, acceptors, confirmed) && fan.more() {
+		var res prepareResult
+		select {
+		case res = <-prepareResultChan:
+			fan.received()
+		case <-fan.timeout():
+			// the fastest quorum is taking too long, try the other acceptors as well.
+			fan.sendRest()
+			continue
+		case <-ctx.Done():
+			return Ballot{}, nil, false, ctx.Err()
+		}
 		if res.err != nil {
-			// conflict occurred
-			numberConflicts++
-			if res.acceptedState.AcceptedBallot.Counter > highBallotConflict.Counter {
-				highBallotConflict = res.acceptedState.AcceptedBallot
-			} else if res.acceptedState.PromisedBallot.Counter > highBallotConflict.Counter {
-				highBallotConflict = res.acceptedState.PromisedBallot
+			causes[res.acceptor.ID] = res.err
+			var conflict *ErrConflict
+			if errors.As(res.err, &conflict) {
+				// conflict occurred; only the state of a conflict can be trusted to fast-forward with.
+				if highBallotConflict.Less(conflict.State.AcceptedBallot) {
+					highBallotConflict = conflict.State.AcceptedBallot
+				}
+				if highBallotConflict.Less(conflict.State.PromisedBallot) {
+					highBallotConflict = conflict.State.PromisedBallot
+				}
 			}
 		} else {
 			// confirmation occurred.
-			numberConfirmations++
-			if res.acceptedState.AcceptedBallot.Counter >= highBallotConfirm.Counter {
+			confirmed = append(confirmed, res.acceptor)
+			if len(confirmed) > 1 && !res.acceptedState.AcceptedBallot.Equal(highBallotConfirm) {
+				agreement = false
+			}
+			if !res.acceptedState.AcceptedBallot.Less(highBallotConfirm) {
 				highBallotConfirm = res.acceptedState.AcceptedBallot
 				currentState = res.acceptedState.State
 			}
-			confirmationsNeeded--
 		}
 	}
 
-	// we didn't get F+1 confirmations
-	if numberConfirmations < confirmationsNeeded {
-		n.Ballot.Counter = highBallotConflict.Counter + 1
-		return nil, fmt.Errorf("confirmations:%v is less than required minimum of:%v", numberConfirmations, confirmationsNeeded)
+	// we didn't get a quorum of confirmations
+	if !quorum.IsQuorum(PreparePhase, acceptors, confirmed) {
+		if ctx.Err() != nil {
+			// the acceptors failed because the proposal was cancelled, not because of a conflict.
+			return Ballot{}, nil, false, ctx.Err()
+		}
+		n.fastForward(key, highBallotConflict)
+		return Ballot{}, nil, false, &ErrQuorumNotReached{Phase: PreparePhase, Confirmations: len(confirmed), Acceptors: noAcceptors, Ballot: highBallotConflict, Causes: causes}
 	}
 
-	return currentState, nil
+	return ballot, currentState, agreement, nil
 }
 
 // Proposer applies the f function to the current state and sends the result, new state,
 // along with the generated Ballot number B (an ”accept” message) to the acceptors.
-// Proposer waits for the F + 1 confirmations.
+// Proposer waits for a quorum of confirmations, as decided by the node's QuorumSystem; F + 1 confirmations by default.
 // Proposer returns the new state to the client.
-func (n *Node) sendAccept(key []byte, currentState []byte, changeFunc ChangeFunction) ([]byte, error) {
+func (n *Node) sendAccept(ctx context.Context, key []byte, b Ballot, currentState []byte, changeFunc ChangeFunction) ([]byte, error) {
+	return n.sendAcceptBallot(ctx, key, b, nil, currentState, changeFunc)
+}
 
+// sendAcceptBallot runs the accept phase with Ballot b.
+// If next is not nil, the acceptors are also asked to promise the next Ballot; see sendAcceptPrepare.
+func (n *Node) sendAcceptBallot(ctx context.Context, key []byte, b Ballot, next *Ballot, currentState []byte, changeFunc ChangeFunction) ([]byte, error) {
 	/*
 		Yes, acceptors should store tuple (promised Ballot, accepted Ballot and an accepted value) per key.
 		Proposers, unlike acceptors, may use the same Ballot number sequence.
@@ -247,62 +556,94 @@ func (n *Node) sendAccept(key []byte, currentState []byte, changeFunc ChangeFunc
 		- Rystsov
 	*/
 	var (
-		noAcceptors         = len(n.nodes)
-		F                   = (noAcceptors - 1) / 2 // number of failures we can tolerate
-		confirmationsNeeded = F + 1
-		highBallotConflict  Ballot
-		numberConflicts     int
-		numberConfirmations int
+		acceptors          = n.acceptAcceptors()
+		noAcceptors        = len(acceptors)
+		confirmed          = []*Node{}
+		highBallotConflict = b
+		causes             = map[uint64]error{}
 	)
 
 	// probably we shouldn't call this method, sendAccept, if we havent called prepare yet and it is finished
 	if noAcceptors < minimumNoAcceptors {
-		return nil, fmt.Errorf("number of acceptors:%v is less than required minimum of:%v", noAcceptors, minimumNoAcceptors)
+		return nil, &ErrTooFewAcceptors{Acceptors: noAcceptors, Minimum: minimumNoAcceptors}
+	}
+	quorum, err := n.quorum()
+	if err != nil {
+		return nil, err
 	}
-	if bytes.Equal(key, acceptedBallotKey(key)) {
-		return nil, fmt.Errorf("the key:%v is reserved for storing kshaka internal state. chose another key", acceptedBallotKey(key))
+	if isReservedKey(key) {
+		return nil, &ErrReservedKey{Key: key}
 	}
 
 	newState, err := changeFunc(currentState)
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("unable to apply the ChangeFunction to value at key:%v", key))
+		return nil, &ErrChangeFunction{Key: key, Err: err}
 	}
 	// TODO: if newState == nil should we save it, or return error??
 	// think about this some more
 
 	type acceptResult struct {
+		acceptor      *Node
 		acceptedState AcceptorState
 		err           error
 	}
 	acceptResultChan := make(chan acceptResult, noAcceptors)
-	for _, a := range n.nodes {
-		go func(a *Node) {
-			acceptedState, err := a.Trans.TransportAccept(n.Ballot, key, newState)
-			acceptResultChan <- acceptResult{acceptedState, err}
-		}(a)
-	}
-
-	for i := 0; i < cap(acceptResultChan) && confirmationsNeeded > 0; i++ {
-		res := <-acceptResultChan
+	fan := n.startFanOut(AcceptPhase, quorum, acceptors, func(a *Node) {
+		go func() {
+			var acceptedState AcceptorState
+			var err error
+			start := time.Now()
+			if next != nil {
+				acceptedState, err = a.Trans.(AcceptPrepareTransport).TransportAcceptPrepare(ctx, b, key, newState, *next)
+			} else {
+				acceptedState, err = a.Trans.TransportAccept(ctx, b, key, newState)
+			}
+			if ctx.Err() == nil {
+				n.latencies.observe(a.ID, time.Since(start))
+			}
+			acceptResultChan <- acceptResult{a, acceptedState, err}
+		}()
+	})
+	defer fan.stop()
+
+	for !quorum.IsQuorum(AcceptPhase, acceptors, confirmed) && fan.more() {
+		var res acceptResult
+		select {
+		case res = <-acceptResultChan:
+			fan.received()
+		case <-fan.timeout():
+			// the fastest quorum is taking too long, try the other acceptors as well.
+			fan.sendRest()
+			continue
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 		if res.err != nil {
-			// conflict occurred
-			numberConflicts++
-			if res.acceptedState.AcceptedBallot.Counter > n.Ballot.Counter {
-				highBallotConflict = res.acceptedState.AcceptedBallot
-			} else if res.acceptedState.PromisedBallot.Counter > n.Ballot.Counter {
-				highBallotConflict = res.acceptedState.PromisedBallot
+			causes[res.acceptor.ID] = res.err
+			var conflict *ErrConflict
+			if errors.As(res.err, &conflict) {
+				// conflict occurred; only the state of a conflict can be trusted to fast-forward with.
+				if highBallotConflict.Less(conflict.State.AcceptedBallot) {
+					highBallotConflict = conflict.State.AcceptedBallot
+				}
+				if highBallotConflict.Less(conflict.State.PromisedBallot) {
+					highBallotConflict = conflict.State.PromisedBallot
+				}
 			}
 		} else {
 			// confirmation occurred.
-			numberConfirmations++
-			confirmationsNeeded--
+			confirmed = append(confirmed, res.acceptor)
 		}
 	}
 
-	// we didn't get F+1 confirmations
-	if numberConfirmations < confirmationsNeeded {
-		n.Ballot.Counter = highBallotConflict.Counter + 1
-		return nil, fmt.Errorf("confirmations:%v is less than required minimum of:%v", numberConfirmations, confirmationsNeeded)
+	// we didn't get a quorum of confirmations
+	if !quorum.IsQuorum(AcceptPhase, acceptors, confirmed) {
+		if ctx.Err() != nil {
+			// the acceptors failed because the proposal was cancelled, not because of a conflict.
+			return nil, ctx.Err()
+		}
+		n.fastForward(key, highBallotConflict)
+		return nil, &ErrQuorumNotReached{Phase: AcceptPhase, Confirmations: len(confirmed), Acceptors: noAcceptors, Ballot: highBallotConflict, Causes: causes}
 	}
 
 	return newState, nil
@@ -313,82 +654,29 @@ func (n *Node) sendAccept(key []byte, currentState []byte, changeFunc ChangeFunc
 // Persists the Ballot number as a promise and returns a confirmation either with an empty value (if it hasn’t accepted any value yet)
 // or with a tuple of an accepted value and its Ballot number.
 func (n *Node) Prepare(b Ballot, key []byte) (AcceptorState, error) {
-	// TODO: this locks are supposed to be per key
-	// not method wide
-	n.Lock()
-	defer n.Unlock()
+	unlock := n.keyLocks.lock(key)
+	defer unlock()
 
-	state, err := n.acceptorStore.Get(key)
-	if err != nil && err.Error() == stableStoreNotFoundErr {
-		// see: issues/10
-		// TODO: do better
-		state, err = nil, nil
-	}
+	txn, err := n.beginData()
 	if err != nil {
-		return AcceptorState{}, errors.Wrap(err, fmt.Sprintf("unable to get state for key:%v from acceptor:%v", key, n.ID))
+		return AcceptorState{}, err
 	}
+	defer txn.Rollback() // nolint: errcheck
 
-	acceptedBallotBytes, err := n.acceptorStore.Get(acceptedBallotKey(key))
-	if err != nil && err.Error() == stableStoreNotFoundErr {
-		// unfortunate way of handling errors
-		// TODO: do better
-		acceptedBallotBytes, err = nil, nil
-	}
+	acceptorState, legacy, err := n.readAcceptorState(txn, key)
 	if err != nil {
-		return AcceptorState{State: state}, errors.Wrap(err, fmt.Sprintf("unable to get acceptedBallot of acceptor:%v", n.ID))
+		return AcceptorState{}, err
 	}
-	var acceptedBallot Ballot
-	if !bytes.Equal(acceptedBallotBytes, nil) {
-		// ie we found an accepted Ballot
-		acceptedBallotReader := bytes.NewReader(acceptedBallotBytes)
-		dec := gob.NewDecoder(acceptedBallotReader)
-		err = dec.Decode(&acceptedBallot)
-		if err != nil {
-			return AcceptorState{State: state}, errors.Wrap(err, fmt.Sprintf("unable to get acceptedBallot of acceptor:%v", n.ID))
-		}
-		// TODO: also take into account the Node ID to resolve tie-breaks
-		if acceptedBallot.Counter > b.Counter {
-			return AcceptorState{AcceptedBallot: acceptedBallot, State: state}, fmt.Errorf("submitted Ballot:%v is less than Ballot:%v of acceptor:%v", b, acceptedBallot, n.ID)
-		}
+	if err := n.checkBallot(b, acceptorState); err != nil {
+		return acceptorState, err
 	}
 
-	promisedBallotBytes, err := n.acceptorStore.Get(promisedBallotKey(key))
-	if err != nil && err.Error() == stableStoreNotFoundErr {
-		// unfortunate way of handling errors
-		// TODO: do better
-		promisedBallotBytes, err = nil, nil
-	}
+	newAcceptorState := AcceptorState{PromisedBallot: b, AcceptedBallot: acceptorState.AcceptedBallot, State: acceptorState.State}
+	err = n.setAcceptorState(txn, key, newAcceptorState, legacy)
 	if err != nil {
-		return AcceptorState{State: state, AcceptedBallot: acceptedBallot}, errors.Wrap(err, fmt.Sprintf("unable to get promisedBallot of acceptor:%v", n.ID))
+		return acceptorState, errors.Wrap(err, fmt.Sprintf("unable to flush Ballot:%v to disk", b))
 	}
-	var promisedBallot Ballot
-	if !bytes.Equal(promisedBallotBytes, nil) {
-		// ie we found an promised Ballot
-		promisedBallotReader := bytes.NewReader(promisedBallotBytes)
-		dec := gob.NewDecoder(promisedBallotReader)
-		err = dec.Decode(&promisedBallot)
-		if err != nil {
-			return AcceptorState{State: state, AcceptedBallot: acceptedBallot}, errors.Wrap(err, fmt.Sprintf("unable to get promisedBallot of acceptor:%v", n.ID))
-		}
-		// TODO: also take into account the Node ID to resolve tie-breaks
-		if promisedBallot.Counter > b.Counter {
-			return AcceptorState{PromisedBallot: promisedBallot, AcceptedBallot: acceptedBallot, State: state}, fmt.Errorf("submitted Ballot:%v is less than Ballot:%v of acceptor:%v", b, promisedBallot, n.ID)
-		}
-	}
-
-	// TODO: this should be flushed to disk
-	var BallotBuffer bytes.Buffer
-	enc := gob.NewEncoder(&BallotBuffer)
-	err = enc.Encode(b)
-	if err != nil {
-		return AcceptorState{AcceptedBallot: acceptedBallot, State: state, PromisedBallot: promisedBallot}, errors.Wrap(err, fmt.Sprintf("unable to encode Ballot:%v", b))
-	}
-
-	err = n.acceptorStore.Set(promisedBallotKey(key), BallotBuffer.Bytes())
-	if err != nil {
-		return AcceptorState{AcceptedBallot: acceptedBallot, State: state, PromisedBallot: promisedBallot}, errors.Wrap(err, fmt.Sprintf("unable to flush Ballot:%v to disk", b))
-	}
-	return AcceptorState{AcceptedBallot: acceptedBallot, State: state, PromisedBallot: b}, nil
+	return newAcceptorState, nil
 }
 
 // Accept handles the accept phase for an acceptor(node).
@@ -402,96 +690,283 @@ func (n *Node) Accept(b Ballot, key []byte, newState []byte) (AcceptorState, err
 		- Rystsov
 	*/
 
-	// we still need to unlock even when using a StableStore as the store of state.
-	// this is because, someone may provide us with non-concurrent safe StableStore
-	n.Lock()
-	defer n.Unlock()
+	// we still need to lock even when using a StableStore as the store of state.
+	// this is because the acceptor state of a key is read and then written as separate operations.
+	unlock := n.keyLocks.lock(key)
+	defer unlock()
 
-	state, err := n.acceptorStore.Get(key)
-	if err != nil && err.Error() == stableStoreNotFoundErr {
-		// unfortunate way of handling errors
-		// TODO: do better
-		state, err = nil, nil
+	txn, err := n.beginData()
+	if err != nil {
+		return AcceptorState{}, err
 	}
+	defer txn.Rollback() // nolint: errcheck
+
+	acceptorState, legacy, err := n.readAcceptorState(txn, key)
 	if err != nil {
-		return AcceptorState{}, errors.Wrap(err, fmt.Sprintf("unable to get state for key:%v from acceptor:%v", key, n.ID))
+		return AcceptorState{}, err
+	}
+	if err := n.checkBallot(b, acceptorState); err != nil {
+		return acceptorState, err
 	}
 
-	acceptedBallotBytes, err := n.acceptorStore.Get(acceptedBallotKey(key))
-	if err != nil && err.Error() == stableStoreNotFoundErr {
-		// unfortunate way of handling errors
-		// TODO: do better
-		acceptedBallotBytes, err = nil, nil
+	// erase the promised Ballot and accept the new state; all in one write, committed in one transaction if the store supports them.
+	newAcceptorState := AcceptorState{AcceptedBallot: b, State: newState}
+	err = n.setAcceptorState(txn, key, newAcceptorState, legacy)
+	if err != nil {
+		return acceptorState, errors.Wrap(err, fmt.Sprintf("unable to flush Ballot:%v and the new state:%v to disk", b, newState))
+	}
+	return newAcceptorState, nil
+}
+
+// AcceptPrepare handles an accept message that also carries the prepare message of the proposer's next proposal on key.
+// The acceptor accepts the tuple (Ballot number b, value) exactly like Accept does and then promises the next Ballot,
+// which lets the proposer skip the prepare phase of its next proposal on key.
+func (n *Node) AcceptPrepare(b Ballot, key []byte, newState []byte, next Ballot) (AcceptorState, error) {
+	if !b.Less(next) {
+		return AcceptorState{}, fmt.Errorf("next Ballot:%v should be greater than submitted Ballot:%v", next, b)
 	}
+
+	unlock := n.keyLocks.lock(key)
+	defer unlock()
+
+	txn, err := n.beginData()
 	if err != nil {
-		return AcceptorState{State: state}, errors.Wrap(err, fmt.Sprintf("unable to get acceptedBallot of acceptor:%v", n.ID))
+		return AcceptorState{}, err
 	}
+	defer txn.Rollback() // nolint: errcheck
 
-	var acceptedBallot Ballot
-	if !bytes.Equal(acceptedBallotBytes, nil) {
-		// ie we found an accepted Ballot
-		acceptedBallotReader := bytes.NewReader(acceptedBallotBytes)
-		dec := gob.NewDecoder(acceptedBallotReader)
-		err = dec.Decode(&acceptedBallot)
-		if err != nil {
-			return AcceptorState{State: state}, errors.Wrap(err, fmt.Sprintf("unable to get acceptedBallot of acceptor:%v", n.ID))
-		}
-		// TODO: also take into account the Node ID to resolve tie-breaks
-		if acceptedBallot.Counter > b.Counter {
-			return AcceptorState{AcceptedBallot: acceptedBallot, State: state}, fmt.Errorf("submitted Ballot:%v is less than Ballot:%v of acceptor:%v", b, acceptedBallot, n.ID)
+	acceptorState, legacy, err := n.readAcceptorState(txn, key)
+	if err != nil {
+		return AcceptorState{}, err
+	}
+	if err := n.checkBallot(b, acceptorState); err != nil {
+		return acceptorState, err
+	}
+
+	newAcceptorState := AcceptorState{PromisedBallot: next, AcceptedBallot: b, State: newState}
+	err = n.setAcceptorState(txn, key, newAcceptorState, legacy)
+	if err != nil {
+		return acceptorState, errors.Wrap(err, fmt.Sprintf("unable to flush Ballot:%v and the new state:%v to disk", b, newState))
+	}
+	return newAcceptorState, nil
+}
+
+// checkBallot returns an *ErrConflict if the acceptor has already seen a Ballot greater than b,
+// or if b is from an older configuration epoch than the acceptor's.
+func (n *Node) checkBallot(b Ballot, acceptorState AcceptorState) error {
+	greatest := acceptorState.AcceptedBallot
+	if greatest.Less(acceptorState.PromisedBallot) {
+		greatest = acceptorState.PromisedBallot
+	}
+	epoch, err := n.currentEpoch()
+	if err != nil {
+		return err
+	}
+	if b.Epoch < epoch || b.Less(greatest) {
+		return &ErrConflict{AcceptorID: n.ID, Submitted: b, Ballot: greatest, Epoch: epoch, State: acceptorState}
+	}
+	return nil
+}
+
+// getAcceptorState reads the (promised Ballot, accepted Ballot, state) tuple that the acceptor stores for key.
+func (n *Node) getAcceptorState(key []byte) (AcceptorState, error) {
+	acceptorState, _, err := n.readAcceptorState(&storeTxn{store: n.dataStore()}, key)
+	return acceptorState, err
+}
+
+// readAcceptorState is like getAcceptorState, but also reports whether the state was read from one of
+// the layouts that older versions of kshaka used; see getLegacyAcceptorState.
+// The data namespace is read through txn.
+func (n *Node) readAcceptorState(txn Txn, key []byte) (AcceptorState, bool, error) {
+	var acceptorState AcceptorState
+	record, err := txn.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		record, err = nil, nil
+	}
+	if err != nil {
+		return acceptorState, false, errors.Wrap(err, fmt.Sprintf("unable to get state for key:%v from acceptor:%v", key, n.ID))
+	}
+	if len(record) == 0 {
+		if n.legacyMigrated {
+			return acceptorState, false, nil
 		}
+		return n.getLegacyAcceptorState(key)
 	}
 
-	promisedBallotBytes, err := n.acceptorStore.Get(promisedBallotKey(key))
-	if err != nil && err.Error() == stableStoreNotFoundErr {
-		// unfortunate way of handling errors
-		// TODO: do better
-		promisedBallotBytes, err = nil, nil
+	acceptorState, err = decodeAcceptorState(record)
+	if err != nil {
+		return acceptorState, false, errors.Wrap(err, fmt.Sprintf("unable to decode state for key:%v from acceptor:%v", key, n.ID))
 	}
+	return acceptorState, false, nil
+}
+
+// setAcceptorState persists the (promised Ballot, accepted Ballot, state) tuple of key as a single record in the data namespace,
+// and commits txn. If legacy is true, the state that was stored in an older layout is removed once txn is committed.
+func (n *Node) setAcceptorState(txn Txn, key []byte, acceptorState AcceptorState, legacy bool) error {
+	record, err := acceptorState.MarshalBinary()
 	if err != nil {
-		return AcceptorState{State: state, AcceptedBallot: acceptedBallot}, errors.Wrap(err, fmt.Sprintf("unable to get promisedBallot of acceptor:%v", n.ID))
+		return errors.Wrap(err, fmt.Sprintf("unable to encode state for key:%v", key))
+	}
+	err = txn.Set(key, record)
+	if err != nil {
+		return err
+	}
+	err = txn.Commit()
+	if err != nil || !legacy {
+		return err
 	}
-	var promisedBallot Ballot
-	if !bytes.Equal(promisedBallotBytes, nil) {
-		// ie we found an promised Ballot
-		promisedBallotReader := bytes.NewReader(promisedBallotBytes)
-		dec := gob.NewDecoder(promisedBallotReader)
-		err = dec.Decode(&promisedBallot)
+	return n.removeLegacyAcceptorState(key)
+}
+
+// getLegacyAcceptorState reads the acceptor state of key from the layouts that older versions of kshaka used,
+// and reports whether it found any. Those kept everything in one keyspace, alongside the keys of the store's other users:
+// first as a single record under acceptorStateKey(key), and before that as the state under key itself
+// with the accepted Ballot and promised Ballot under two separate keys.
+// The next write of key migrates it to the data namespace.
+func (n *Node) getLegacyAcceptorState(key []byte) (AcceptorState, bool, error) {
+	var acceptorState AcceptorState
+	record, err := n.acceptorStore.Get(acceptorStateKey(key))
+	if errors.Is(err, ErrNotFound) {
+		record, err = nil, nil
+	}
+	if err != nil {
+		return acceptorState, false, errors.Wrap(err, fmt.Sprintf("unable to get state for key:%v from acceptor:%v", key, n.ID))
+	}
+	if len(record) > 0 {
+		acceptorState, err = decodeAcceptorState(record)
 		if err != nil {
-			return AcceptorState{State: state, AcceptedBallot: acceptedBallot}, errors.Wrap(err, fmt.Sprintf("unable to get promisedBallot of acceptor:%v", n.ID))
-		}
-		// TODO: also take into account the Node ID to resolve tie-breaks
-		if promisedBallot.Counter > b.Counter {
-			return AcceptorState{PromisedBallot: promisedBallot, AcceptedBallot: acceptedBallot, State: state}, fmt.Errorf("submitted Ballot:%v is less than Ballot:%v of acceptor:%v", b, promisedBallot, n.ID)
+			return acceptorState, false, errors.Wrap(err, fmt.Sprintf("unable to decode state for key:%v from acceptor:%v", key, n.ID))
 		}
+		return acceptorState, true, nil
+	}
+
+	acceptorState.AcceptedBallot, err = n.getLegacyBallot(acceptedBallotKey(key))
+	if err != nil {
+		return acceptorState, false, errors.Wrap(err, fmt.Sprintf("unable to get acceptedBallot of acceptor:%v", n.ID))
+	}
+	acceptorState.PromisedBallot, err = n.getLegacyBallot(promisedBallotKey(key))
+	if err != nil {
+		return acceptorState, false, errors.Wrap(err, fmt.Sprintf("unable to get promisedBallot of acceptor:%v", n.ID))
 	}
+	state, err := n.acceptorStore.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		state, err = nil, nil
+	}
+	if err != nil {
+		return acceptorState, false, errors.Wrap(err, fmt.Sprintf("unable to get state for key:%v from acceptor:%v", key, n.ID))
+	}
+	if len(state) > 0 {
+		acceptorState.State = state
+	}
+	found := acceptorState.State != nil || !acceptorState.AcceptedBallot.Equal(Ballot{}) || !acceptorState.PromisedBallot.Equal(Ballot{})
+	return acceptorState, found, nil
+}
 
-	// erase promised Ballot
-	err = n.acceptorStore.Set(promisedBallotKey(key), nil)
+// migrateLegacyState moves every key that is still laid out as older versions of kshaka stored it to the data namespace,
+// and records in the meta namespace that it has; from then on, keys without a record are no longer looked up in the old layouts.
+// Stores that do not implement KeyIterator are not migrated, their keys are migrated one at a time as they are written.
+func (n *Node) migrateLegacyState() error {
+	migrated, err := n.metaStore().GetUint64(legacyMigratedMetaKey)
+	if errors.Is(err, ErrNotFound) {
+		migrated, err = 0, nil
+	}
 	if err != nil {
-		return AcceptorState{AcceptedBallot: acceptedBallot, State: state, PromisedBallot: promisedBallot}, errors.Wrap(err, fmt.Sprintf("unable to erase Ballot:%v", b))
+		return errors.Wrap(err, fmt.Sprintf("unable to get the layout of the store of acceptor:%v", n.ID))
+	}
+	if migrated == 1 {
+		n.legacyMigrated = true
+		return nil
 	}
 
-	var BallotBuffer bytes.Buffer
-	enc := gob.NewEncoder(&BallotBuffer)
-	err = enc.Encode(b)
+	keys, err := n.legacyKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = n.migrateLegacyKey(key)
+		if err != nil {
+			return err
+		}
+	}
+	err = n.metaStore().SetUint64(legacyMigratedMetaKey, 1)
 	if err != nil {
-		return AcceptorState{AcceptedBallot: acceptedBallot, State: state}, errors.Wrap(err, fmt.Sprintf("unable to encode Ballot:%v", b))
+		return errors.Wrap(err, fmt.Sprintf("unable to record the layout of the store of acceptor:%v", n.ID))
 	}
-	// TODO. NB: it is possible, from the following logic, for an acceptor to accept a Ballot
-	// but not accept the new state/value. ie if the call to acceptorStore.Set(acceptedBallotKey, BallotBuffer.Bytes()) succeeds
-	// but acceptorStore.Set(key, state) fails.
-	// we should think about the ramifications of that for a second.
-	err = n.acceptorStore.Set(acceptedBallotKey(key), BallotBuffer.Bytes())
+	n.legacyMigrated = true
+	return nil
+}
+
+// migrateLegacyKey moves the acceptor state of key from the layouts that older versions of kshaka used to the data namespace.
+func (n *Node) migrateLegacyKey(key []byte) error {
+	unlock := n.keyLocks.lock(key)
+	defer unlock()
+
+	txn, err := n.beginData()
 	if err != nil {
-		return AcceptorState{AcceptedBallot: acceptedBallot, State: state}, errors.Wrap(err, fmt.Sprintf("unable to flush Ballot:%v to disk", b))
+		return err
 	}
+	defer txn.Rollback() // nolint: errcheck
 
-	err = n.acceptorStore.Set(key, newState)
+	acceptorState, legacy, err := n.readAcceptorState(txn, key)
 	if err != nil {
-		return AcceptorState{AcceptedBallot: b, State: state}, errors.Wrap(err, fmt.Sprintf("unable to flush the new state:%v to disk", state))
+		return err
+	}
+	if !legacy {
+		// the key already has a record in the data namespace, which supersedes the old layouts.
+		// The transaction is rolled back first, since stores like boltStore only allow one writer at a time.
+		err = txn.Rollback()
+		if err != nil {
+			return err
+		}
+		return n.removeLegacyAcceptorState(key)
+	}
+	return n.setAcceptorState(txn, key, acceptorState, legacy)
+}
+
+// legacyKeys returns the keys that are still laid out as older versions of kshaka stored them.
+// It returns none once they have all been migrated, or if the store does not implement KeyIterator.
+func (n *Node) legacyKeys() ([][]byte, error) {
+	legacy, ok := n.acceptorStore.(KeyIterator)
+	if !ok || n.legacyMigrated {
+		return nil, nil
+	}
+	keys := [][]byte{}
+	seen := map[string]bool{}
+	for _, prefix := range [][]byte{acceptorStateKey(nil), acceptedBallotKey(nil), promisedBallotKey(nil)} {
+		err := legacy.IteratePrefix(prefix, func(k []byte, val []byte) error {
+			k = bytes.TrimPrefix(k, prefix)
+			if len(val) > 0 && !seen[string(k)] {
+				keys = append(keys, append([]byte{}, k...))
+				seen[string(k)] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("unable to list the keys of acceptor:%v", n.ID))
+		}
 	}
+	return keys, nil
+}
 
-	return AcceptorState{AcceptedBallot: b, State: state}, nil
+// removeLegacyAcceptorState removes the state of key from the layouts that older versions of kshaka used.
+func (n *Node) removeLegacyAcceptorState(key []byte) error {
+	for _, k := range [][]byte{acceptorStateKey(key), key, acceptedBallotKey(key), promisedBallotKey(key)} {
+		err := removeKey(n.acceptorStore, k)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to remove the old state of key:%v from acceptor:%v", key, n.ID))
+		}
+	}
+	return nil
+}
 
+func (n *Node) getLegacyBallot(ballotKey []byte) (Ballot, error) {
+	var b Ballot
+	ballotBytes, err := n.acceptorStore.Get(ballotKey)
+	if errors.Is(err, ErrNotFound) {
+		ballotBytes, err = nil, nil
+	}
+	if err != nil || len(ballotBytes) == 0 {
+		return b, err
+	}
+	return decodeBallot(ballotBytes)
 }
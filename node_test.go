@@ -1,7 +1,16 @@
 package kshaka
 
 import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestNode_incBallot(t *testing.T) {
@@ -17,9 +26,11 @@ func TestNode_incBallot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			n := tt.n
-			n.incBallot()
-			n.incBallot()
-			n.incBallot()
+			for i := 0; i < 3; i++ {
+				if _, err := n.incBallot(nil); err != nil {
+					t.Fatalf("\n p.incBallot() \nerr = %v", err)
+				}
+			}
 
 			if n.Ballot.Counter != 3 {
 				t.Errorf("\n p.incBallot() *3 \ngot = %#+v, \nwanted = %#+v", n.Ballot.Counter, 3)
@@ -28,6 +39,87 @@ func TestNode_incBallot(t *testing.T) {
 	}
 }
 
+// countingStore is an InmemStore that counts the calls made to it.
+type countingStore struct {
+	*InmemStore
+	setCalls       int
+	getCalls       int
+	setUint64Calls int
+}
+
+func (c *countingStore) Set(key []byte, val []byte) error {
+	c.setCalls++
+	return c.InmemStore.Set(key, val)
+}
+
+func (c *countingStore) Get(key []byte) ([]byte, error) {
+	c.getCalls++
+	return c.InmemStore.Get(key)
+}
+
+func (c *countingStore) SetUint64(key []byte, val uint64) error {
+	c.setUint64Calls++
+	return c.InmemStore.SetUint64(key, val)
+}
+
+// Begin counts the Gets and Sets of the transaction as calls made to the store.
+func (c *countingStore) Begin() (Txn, error) {
+	txn, err := c.InmemStore.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &countingStoreTxn{Txn: txn, store: c}, nil
+}
+
+type countingStoreTxn struct {
+	Txn
+	store *countingStore
+}
+
+func (t *countingStoreTxn) Set(key []byte, val []byte) error {
+	t.store.setCalls++
+	return t.Txn.Set(key, val)
+}
+
+func (t *countingStoreTxn) Get(key []byte) ([]byte, error) {
+	t.store.getCalls++
+	return t.Txn.Get(key)
+}
+
+func TestNode_ballotCounterPersisted(t *testing.T) {
+	store := &countingStore{InmemStore: &InmemStore{}}
+	n := NewNode(1, store)
+	for i := 0; i < 10; i++ {
+		if _, err := n.incBallot(nil); err != nil {
+			t.Fatalf("\n p.incBallot() \nerr = %v", err)
+		}
+	}
+	// one call to reserve the lease, and one to record that the store holds no state in the layouts of older versions.
+	if store.setUint64Calls != 2 {
+		t.Errorf("\n store.SetUint64 calls \ngot = %#+v, \nwanted = %#+v", store.setUint64Calls, 2)
+	}
+	issued := n.Ballot
+
+	// the node restarts with the same store.
+	restarted := NewNode(1, store)
+	if _, err := restarted.incBallot(nil); err != nil {
+		t.Fatalf("\n p.incBallot() \nerr = %v", err)
+	}
+	if !issued.Less(restarted.Ballot) {
+		t.Errorf("\n restarted node reused Ballot \ngot = %#+v, \nwanted greater than = %#+v", restarted.Ballot, issued)
+	}
+
+	// using up a lease reserves another one.
+	for i := 0; i < ballotCounterLease; i++ {
+		if _, err := restarted.incBallot(nil); err != nil {
+			t.Fatalf("\n p.incBallot() \nerr = %v", err)
+		}
+	}
+	if store.setUint64Calls != 4 {
+		t.Errorf("\n store.SetUint64 calls \ngot = %#+v, \nwanted = %#+v", store.setUint64Calls, 4)
+	}
+}
+
 func TestMingleNodes(t *testing.T) {
 	kv := map[string][]byte{"": []byte("")}
 	store := &InmemStore{kv: kv}
@@ -93,3 +185,376 @@ func TestMingleNodesMoreTimes(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyLocker(t *testing.T) {
+	kl := &keyLocker{}
+
+	// operations on the same key are mutually exclusive, the race detector should not complain.
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := kl.lock([]byte("foo"))
+			defer unlock()
+			counter++
+		}()
+	}
+	wg.Wait()
+	if counter != 50 {
+		t.Errorf("\n keyLocker.lock() \ngot = %#+v, \nwanted = %#+v", counter, 50)
+	}
+
+	// operations on different keys do not block each other.
+	unlock := kl.lock([]byte("foo"))
+	defer unlock()
+	done := make(chan struct{})
+	go func() {
+		// with defaultKeyLockStripes stripes, foo and bar do not share a stripe.
+		unlockBar := kl.lock([]byte("bar"))
+		unlockBar()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("\n keyLocker.lock() \nlocking key bar was blocked by key foo")
+	}
+}
+
+// diskLatencyStore is an InmemStore whose writes take as long as a flush to disk typically would.
+type diskLatencyStore struct {
+	*InmemStore
+	latency time.Duration
+}
+
+func (d *diskLatencyStore) Set(key []byte, val []byte) error {
+	time.Sleep(d.latency)
+	return d.InmemStore.Set(key, val)
+}
+
+// Begin makes the commit of each transaction take as long as a write, since that is when the transaction is flushed to disk.
+func (d *diskLatencyStore) Begin() (Txn, error) {
+	txn, err := d.InmemStore.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &diskLatencyTxn{Txn: txn, latency: d.latency}, nil
+}
+
+type diskLatencyTxn struct {
+	Txn
+	latency time.Duration
+}
+
+func (t *diskLatencyTxn) Commit() error {
+	time.Sleep(t.latency)
+	return t.Txn.Commit()
+}
+
+func benchmarkAcceptorManyKeys(b *testing.B, stripes int) {
+	n := NewNode(1, &diskLatencyStore{InmemStore: &InmemStore{kv: map[string][]byte{}}, latency: 100 * time.Microsecond})
+	n.keyLocks.size = stripes
+	var keyID uint64
+
+	// 64 goroutines per CPU, each operating on its own key.
+	b.SetParallelism(64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// each goroutine works on its own set of keys.
+		key := []byte(fmt.Sprintf("key-%d", atomic.AddUint64(&keyID, 1)))
+		var counter uint64
+		for pb.Next() {
+			counter++
+			ballot := Ballot{Counter: counter, NodeID: 1}
+			if _, err := n.Prepare(ballot, key); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := n.Accept(ballot, key, []byte("val")); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkAcceptorManyKeys compares the throughput of an acceptor that serializes all keys behind one lock
+// with that of an acceptor that locks per key, when many keys are operated on concurrently.
+// The store simulates disk latency, which is what acceptors with a durable store mostly wait on.
+func BenchmarkAcceptorManyKeys(b *testing.B) {
+	b.Run("one lock", func(b *testing.B) { benchmarkAcceptorManyKeys(b, 1) })
+	b.Run("per key locks", func(b *testing.B) { benchmarkAcceptorManyKeys(b, defaultKeyLockStripes) })
+}
+
+func TestAcceptorTieBreak(t *testing.T) {
+	n := NewNode(1, &InmemStore{kv: map[string][]byte{}})
+	key := []byte("foo")
+
+	// two proposers with equal counters; the one with the greater NodeID wins.
+	b1 := Ballot{Counter: 1, NodeID: 1}
+	b2 := Ballot{Counter: 1, NodeID: 2}
+	if _, err := n.Prepare(b1, key); err != nil {
+		t.Fatalf("\n n.Prepare(%v) \nerr = %v", b1, err)
+	}
+	if _, err := n.Prepare(b2, key); err != nil {
+		t.Fatalf("\n n.Prepare(%v) \nerr = %v", b2, err)
+	}
+	if _, err := n.Accept(b1, key, []byte("one")); err == nil {
+		t.Errorf("\n n.Accept(%v) \nwanted a conflict since %v was promised", b1, b2)
+	}
+	if _, err := n.Accept(b2, key, []byte("two")); err != nil {
+		t.Errorf("\n n.Accept(%v) \nerr = %v", b2, err)
+	}
+	if _, err := n.Prepare(b1, key); err == nil {
+		t.Errorf("\n n.Prepare(%v) \nwanted a conflict since %v was accepted", b1, b2)
+	}
+}
+
+func TestProposeEqualCounters(t *testing.T) {
+	acceptors := []*Node{}
+	for i := uint64(1); i <= 3; i++ {
+		a := NewNode(i, &InmemStore{kv: map[string][]byte{}})
+		a.AddTransport(&InmemTransport{Node: a})
+		acceptors = append(acceptors, a)
+	}
+	// the proposers start with equal counters and are only told apart by their IDs.
+	proposers := []*Node{NewNode(11, &InmemStore{}), NewNode(12, &InmemStore{})}
+	for _, p := range proposers {
+		p.nodes = acceptors
+	}
+
+	var incFunc ChangeFunction = func(current []byte) ([]byte, error) {
+		if current == nil {
+			return []byte("1"), nil
+		}
+		c, err := strconv.Atoi(string(current))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(c + 1)), nil
+	}
+
+	const proposalsPerProposer = 20
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		successes = map[string]uint64{}
+	)
+	key := []byte("counter")
+	for _, p := range proposers {
+		wg.Add(1)
+		go func(p *Node) {
+			defer wg.Done()
+			for i := 0; i < proposalsPerProposer; i++ {
+				newstate, err := p.Propose(key, incFunc)
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				if id, ok := successes[string(newstate)]; ok {
+					t.Errorf("\n proposers %v and %v both successfully set the counter to %s", id, p.ID, newstate)
+				}
+				successes[string(newstate)] = p.ID
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	reader := NewNode(13, &InmemStore{})
+	reader.nodes = acceptors
+	reader.Ballot.Counter = 10 * proposalsPerProposer
+	current, err := reader.Propose(key, func(current []byte) ([]byte, error) { return current, nil })
+	if err != nil {
+		t.Fatalf("\n reader.Propose() \nerr = %v", err)
+	}
+	final, _ := strconv.Atoi(string(current))
+	if final < len(successes) || final > 2*proposalsPerProposer {
+		t.Errorf("\n counter \ngot = %v, \nwanted between %v and %v", final, len(successes), 2*proposalsPerProposer)
+	}
+}
+
+func TestAcceptorStateRecord(t *testing.T) {
+	store := &countingStore{InmemStore: &InmemStore{}}
+	n := NewNode(1, store)
+	key := []byte("foo")
+
+	if _, err := n.Prepare(Ballot{Counter: 1, NodeID: 1}, key); err != nil {
+		t.Fatalf("\n n.Prepare() \nerr = %v", err)
+	}
+	store.setCalls, store.getCalls = 0, 0
+	if _, err := n.Prepare(Ballot{Counter: 2, NodeID: 1}, key); err != nil {
+		t.Fatalf("\n n.Prepare() \nerr = %v", err)
+	}
+	if store.getCalls != 1 || store.setCalls != 1 {
+		t.Errorf("\n n.Prepare() \ngot %v Gets and %v Sets, \nwanted 1 Get and 1 Set", store.getCalls, store.setCalls)
+	}
+
+	store.setCalls, store.getCalls = 0, 0
+	if _, err := n.Accept(Ballot{Counter: 2, NodeID: 1}, key, []byte("bar")); err != nil {
+		t.Fatalf("\n n.Accept() \nerr = %v", err)
+	}
+	if store.getCalls != 1 || store.setCalls != 1 {
+		t.Errorf("\n n.Accept() \ngot %v Gets and %v Sets, \nwanted 1 Get and 1 Set", store.getCalls, store.setCalls)
+	}
+}
+
+func TestAcceptorStateLegacyMigration(t *testing.T) {
+	store := &InmemStore{}
+	key := []byte("foo")
+	acceptedBallot := Ballot{Counter: 7, NodeID: 2}
+	promisedBallot := Ballot{Counter: 8, NodeID: 3}
+
+	// a store laid out with the state and Ballots of a key under separate keys, with the Ballots gob encoded.
+	for ballotKey, b := range map[string]Ballot{string(acceptedBallotKey(key)): acceptedBallot, string(promisedBallotKey(key)): promisedBallot} {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(gobBallot(b)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set([]byte(ballotKey), buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Set(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNode(1, store)
+	// the promised Ballot is respected.
+	acceptorState, err := n.Prepare(Ballot{Counter: 8, NodeID: 1}, key)
+	if err == nil {
+		t.Errorf("\n n.Prepare() \nwanted a conflict with promised Ballot:%v", promisedBallot)
+	}
+	want := AcceptorState{PromisedBallot: promisedBallot, AcceptedBallot: acceptedBallot, State: []byte("bar")}
+	if !reflect.DeepEqual(acceptorState, want) {
+		t.Errorf("\n n.Prepare() \ngot = %#+v, \nwanted = %#+v", acceptorState, want)
+	}
+
+	// the node migrated the key to a single record in the data namespace when it started.
+	newBallot := Ballot{Counter: 9, NodeID: 1}
+	if _, err = n.Prepare(newBallot, key); err != nil {
+		t.Fatalf("\n n.Prepare() \nerr = %v", err)
+	}
+	if _, err = n.dataStore().Get(key); err != nil {
+		t.Fatalf("\n n.dataStore().Get(key) \nerr = %v", err)
+	}
+	for _, k := range [][]byte{key, acceptedBallotKey(key), promisedBallotKey(key)} {
+		if _, err = store.Get(k); !errors.Is(err, ErrNotFound) {
+			t.Errorf("\n store.Get(%q) \ngot = %v, \nwanted = %v", k, err, ErrNotFound)
+		}
+	}
+	acceptorState, err = n.getAcceptorState(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = AcceptorState{PromisedBallot: newBallot, AcceptedBallot: acceptedBallot, State: []byte("bar")}
+	if !reflect.DeepEqual(acceptorState, want) {
+		t.Errorf("\n n.getAcceptorState() \ngot = %#+v, \nwanted = %#+v", acceptorState, want)
+	}
+}
+
+// singleWriterStore is an InmemStore that, like boltStore, refuses writes made outside of the transaction that is open.
+type singleWriterStore struct {
+	*InmemStore
+	open int
+}
+
+func (s *singleWriterStore) Set(key []byte, val []byte) error {
+	if s.open > 0 {
+		return errors.New("a transaction is open")
+	}
+	return s.InmemStore.Set(key, val)
+}
+
+func (s *singleWriterStore) Delete(key []byte) error {
+	if s.open > 0 {
+		return errors.New("a transaction is open")
+	}
+	return s.InmemStore.Delete(key)
+}
+
+func (s *singleWriterStore) Begin() (Txn, error) {
+	txn, err := s.InmemStore.Begin()
+	if err != nil {
+		return nil, err
+	}
+	s.open++
+	return &singleWriterTxn{Txn: txn, store: s}, nil
+}
+
+type singleWriterTxn struct {
+	Txn
+	store *singleWriterStore
+	done  bool
+}
+
+func (t *singleWriterTxn) Commit() error {
+	t.close()
+	return t.Txn.Commit()
+}
+
+func (t *singleWriterTxn) Rollback() error {
+	t.close()
+	return t.Txn.Rollback()
+}
+
+func (t *singleWriterTxn) close() {
+	if !t.done {
+		t.done = true
+		t.store.open--
+	}
+}
+
+func TestLegacyMigrationSuperseded(t *testing.T) {
+	store := &singleWriterStore{InmemStore: &InmemStore{}}
+	key := []byte("foo")
+	n := NewNode(1, store)
+	if _, err := n.Accept(Ballot{Counter: 1, NodeID: 2}, key, []byte("new")); err != nil {
+		t.Fatalf("\n n.Accept() \nerr = %v", err)
+	}
+	// an older version of kshaka has left its own state of the key behind.
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gobBallot(Ballot{Counter: 1, NodeID: 3})); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(acceptedBallotKey(key), buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(key, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetUint64(append(namespacePrefix(metaNamespace), legacyMigratedMetaKey...), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	n = NewNode(1, store)
+	for _, k := range [][]byte{key, acceptedBallotKey(key)} {
+		if _, err := store.Get(k); !errors.Is(err, ErrNotFound) {
+			t.Errorf("\n store.Get(%q) \ngot = %v, \nwanted = %v", k, err, ErrNotFound)
+		}
+	}
+	acceptorState, err := n.getAcceptorState(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(acceptorState.State, []byte("new")) {
+		t.Errorf("\n n.getAcceptorState() \ngot = %s, \nwanted = %s", acceptorState.State, "new")
+	}
+}
+
+func TestLegacyMigrationRecorded(t *testing.T) {
+	store := &countingStore{InmemStore: &InmemStore{}}
+	NewNode(1, store)
+
+	// the restarted node knows that there is nothing left to migrate,
+	// so a key without state only costs a read of the data namespace.
+	n := NewNode(1, store)
+	store.getCalls = 0
+	if _, err := n.Prepare(Ballot{Counter: 1, NodeID: 2}, []byte("foo")); err != nil {
+		t.Fatalf("\n n.Prepare() \nerr = %v", err)
+	}
+	if store.getCalls != 1 {
+		t.Errorf("\n store.Get calls \ngot = %#+v, \nwanted = %#+v", store.getCalls, 1)
+	}
+}
@@ -0,0 +1,91 @@
+package kshaka
+
+import (
+	"context"
+)
+
+/*
+One round trip writes are the optimization described in the CASPaxos paper where a proposer merges the prepare message
+of its next proposal on a key into the accept message of the current proposal on that key.
+Once the acceptors have accepted the current proposal and promised the next Ballot,
+the proposer knows the current state of the key and can go straight to the accept phase for its next proposal on it.
+If anyone else changes the key in between, the acceptors reply with conflicts and the proposer falls back to two phases.
+*/
+
+// preparedKey is a Ballot that a quorum of acceptors has promised to a proposer for its next proposal on a key,
+// together with the state that the key had when the Ballot was promised.
+type preparedKey struct {
+	ballot Ballot
+	state  []byte
+}
+
+// maxPreparedKeys is the number of keys for which a node keeps the Ballots promised for its next proposal.
+// A key whose Ballot is evicted takes two round trips on its next proposal.
+const maxPreparedKeys = 10000
+
+// EnableOneRoundTrip makes the node piggyback the prepare message of its next proposal on a key
+// onto the accept message of its current proposal on that key.
+// Subsequent proposals made by the node on the same key then take one round trip to the acceptors instead of two.
+// It only takes effect if the Transport of every acceptor implements AcceptPrepareTransport, and its CanAcceptPrepare returns true.
+//
+// A proposal that skips the prepare phase and conflicts falls back to two phases on its own, without going through the RetryPolicy.
+// Some acceptors may have accepted the state that the ChangeFunction produced in the first attempt,
+// in which case the fallback applies the ChangeFunction again on top of that state. Only enable one round trip writes
+// if applying the ChangeFunctions that the node is given twice is harmless; eg ones that set a value, rather than append to it.
+func (n *Node) EnableOneRoundTrip() {
+	n.oneRoundTrip = true
+}
+
+// canOneRoundTrip reports whether one round trip writes are enabled and supported by all the acceptors.
+func (n *Node) canOneRoundTrip() bool {
+	if !n.oneRoundTrip {
+		return false
+	}
+	for _, a := range n.acceptAcceptors() {
+		t, ok := a.Trans.(AcceptPrepareTransport)
+		if !ok || !t.CanAcceptPrepare() {
+			return false
+		}
+	}
+	return true
+}
+
+// takePrepared removes and returns the Ballot that was promised for the next proposal on key, if any.
+// Each promised Ballot can only be used once.
+func (n *Node) takePrepared(key []byte) (preparedKey, bool) {
+	n.preparedMu.Lock()
+	defer n.preparedMu.Unlock()
+	if n.prepared == nil {
+		return preparedKey{}, false
+	}
+	prepared, ok := n.prepared.remove(key)
+	if !ok {
+		return preparedKey{}, false
+	}
+	return prepared.(preparedKey), true
+}
+
+func (n *Node) putPrepared(key []byte, prepared preparedKey) {
+	n.preparedMu.Lock()
+	defer n.preparedMu.Unlock()
+	if n.prepared == nil {
+		n.prepared = newLRU(maxPreparedKeys)
+	}
+	n.prepared.set(key, prepared)
+}
+
+// sendAcceptPrepare runs the accept phase with Ballot b on currentState, asking the acceptors to also promise a new Ballot.
+// If a quorum accepts, the new Ballot and state are cached for the next proposal on key.
+func (n *Node) sendAcceptPrepare(ctx context.Context, key []byte, b Ballot, currentState []byte, changeFunc ChangeFunction) ([]byte, error) {
+	next, err := n.incBallot(key)
+	if err != nil {
+		return nil, err
+	}
+
+	newState, err := n.sendAcceptBallot(ctx, key, b, &next, currentState, changeFunc)
+	if err != nil {
+		return nil, err
+	}
+	n.putPrepared(key, preparedKey{ballot: next, state: newState})
+	return newState, nil
+}
@@ -0,0 +1,170 @@
+package kshaka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// prepareLog records the Ballots of the prepare messages that are delivered.
+type prepareLog struct {
+	mu      sync.Mutex
+	ballots []Ballot
+}
+
+// since counts the prepare messages sent by proposer with a Ballot greater than b.
+func (pl *prepareLog) since(proposer *Node, b Ballot) int {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	count := 0
+	for _, pb := range pl.ballots {
+		if pb.NodeID == proposer.ID && b.Less(pb) {
+			count++
+		}
+	}
+	return count
+}
+
+// prepareLoggingTransport is an InmemTransport that logs the prepare messages it delivers.
+type prepareLoggingTransport struct {
+	InmemTransport
+	log *prepareLog
+}
+
+func (pt *prepareLoggingTransport) TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error) {
+	pt.log.mu.Lock()
+	pt.log.ballots = append(pt.log.ballots, b)
+	pt.log.mu.Unlock()
+	return pt.InmemTransport.TransportPrepare(ctx, b, key)
+}
+
+func TestOneRoundTrip(t *testing.T) {
+	var appendFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return append(append([]byte{}, current...), val...), nil
+		}
+	}
+
+	prepares := &prepareLog{}
+	nodes := []*Node{}
+	for i := uint64(1); i <= 3; i++ {
+		n := NewNode(i, &InmemStore{})
+		n.AddTransport(&prepareLoggingTransport{InmemTransport: InmemTransport{Node: n}, log: prepares})
+		nodes = append(nodes, n)
+	}
+	MingleNodes(nodes...)
+	proposer := nodes[0]
+	proposer.EnableOneRoundTrip()
+	key := []byte("foo")
+
+	steps := []struct {
+		name         string
+		before       func()
+		val          string
+		want         []byte
+		wantPrepares bool
+	}{
+		{name: "first proposal takes two phases", val: "a", want: []byte("a"), wantPrepares: true},
+		{name: "next proposal skips the prepare phase", val: "b", want: []byte("ab"), wantPrepares: false},
+		{name: "and so does the one after", val: "c", want: []byte("abc"), wantPrepares: false},
+		{name: "conflict falls back to two phases",
+			before: func() {
+				// another proposer changes the key in between.
+				nodes[1].Ballot.Counter = 100
+				if _, err := nodes[1].Propose(key, appendFunc([]byte("X"))); err != nil {
+					t.Fatalf("\nnode.Propose() \nerr = %v", err)
+				}
+			},
+			val: "d", want: []byte("abcXd"), wantPrepares: true},
+		{name: "one round trip resumes after the fallback", val: "e", want: []byte("abcXde"), wantPrepares: false},
+	}
+	for _, step := range steps {
+		if step.before != nil {
+			step.before()
+		}
+		ballotBefore := proposer.Ballot
+		newstate, err := proposer.Propose(key, appendFunc([]byte(step.val)))
+		if err != nil {
+			t.Fatalf("%v: \nnode.Propose() \nerr = %v", step.name, err)
+		}
+		if !reflect.DeepEqual(newstate, step.want) {
+			t.Errorf("%v: \nnode.Propose() \ngot= %s, \nwant = %s", step.name, newstate, step.want)
+		}
+		if got := prepares.since(proposer, ballotBefore) > 0; got != step.wantPrepares {
+			t.Errorf("%v: \nsent prepare messages \ngot= %v, \nwant = %v", step.name, got, step.wantPrepares)
+		}
+	}
+}
+
+// noAcceptPrepareTransport is an InmemTransport whose acceptor cannot be sent accept messages that carry a prepare message,
+// like an HTTPtransport without an AcceptPrepareURI.
+type noAcceptPrepareTransport struct {
+	InmemTransport
+}
+
+func (nt *noAcceptPrepareTransport) TransportAcceptPrepare(ctx context.Context, b Ballot, key []byte, state []byte, next Ballot) (AcceptorState, error) {
+	return AcceptorState{}, errors.New("no AcceptPrepareURI")
+}
+
+func (nt *noAcceptPrepareTransport) CanAcceptPrepare() bool {
+	return false
+}
+
+func TestOneRoundTripUnsupported(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+
+	nodes := []*Node{}
+	for i := uint64(1); i <= 3; i++ {
+		n := NewNode(i, &InmemStore{})
+		if i > 1 {
+			n.AddTransport(&noAcceptPrepareTransport{InmemTransport: InmemTransport{Node: n}})
+		} else {
+			n.AddTransport(&InmemTransport{Node: n})
+		}
+		nodes = append(nodes, n)
+	}
+	MingleNodes(nodes...)
+	proposer := nodes[0]
+	proposer.EnableOneRoundTrip()
+	key := []byte("foo")
+
+	for _, val := range []string{"a", "b"} {
+		newstate, err := proposer.Propose(key, setFunc([]byte(val)))
+		if err != nil {
+			t.Fatalf("\nnode.Propose() \nerr = %v", err)
+		}
+		if !reflect.DeepEqual(newstate, []byte(val)) {
+			t.Errorf("\nnode.Propose() \ngot= %s, \nwant = %s", newstate, val)
+		}
+	}
+	if _, ok := proposer.takePrepared(key); ok {
+		t.Errorf("\nnode.takePrepared() \nwanted no Ballot promised for the next proposal")
+	}
+}
+
+func TestPreparedBounded(t *testing.T) {
+	n := NewNode(1, &InmemStore{})
+	for i := 0; i <= maxPreparedKeys; i++ {
+		n.putPrepared([]byte(fmt.Sprintf("key-%d", i)), preparedKey{ballot: Ballot{Counter: uint64(i), NodeID: 1}})
+	}
+	// the least recently used key was evicted.
+	if _, ok := n.takePrepared([]byte("key-0")); ok {
+		t.Errorf("\nn.takePrepared(key-0) \ngot= %v, \nwant = %v", ok, false)
+	}
+	last := []byte(fmt.Sprintf("key-%d", maxPreparedKeys))
+	prepared, ok := n.takePrepared(last)
+	if !ok || prepared.ballot.Counter != maxPreparedKeys {
+		t.Errorf("\nn.takePrepared(%s) \ngot= %v %v, \nwant = %v", last, prepared.ballot, ok, maxPreparedKeys)
+	}
+	// each promised Ballot is only used once.
+	if _, ok := n.takePrepared(last); ok {
+		t.Errorf("\nn.takePrepared(%s) \ngot= %v, \nwant = %v", last, ok, false)
+	}
+}
@@ -0,0 +1,175 @@
+//go:build pebble
+// +build pebble
+
+/*
+Package pebbleStore provides a kshaka StableStore that is backed by github.com/cockroachdb/pebble, an LSM-tree storage engine.
+It suits acceptors with write heavy workloads; writes are appended to pebble's write-ahead log and memtable
+instead of rewriting the pages of a B-tree.
+
+The store implements kshaka.TxnStore with pebble's indexed batches; so the new state of a key is written, for each Prepare and Accept,
+as one batch. It also implements kshaka.KeyIterator and kshaka.DeleteStore.
+
+Pebble needs a newer Go than the rest of kshaka, so the package is only built with the pebble build tag:
+
+	go test -tags pebble ./pebbleStore
+*/
+package pebbleStore
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/komuw/kshaka"
+	"github.com/pkg/errors"
+)
+
+// Options configures a Store.
+type Options struct {
+	// NoSync skips syncing pebble's write-ahead log after every write.
+	// Like boltStore.Options.NoSync, it gives up the durability that kshaka.StableStore requires.
+	NoSync bool
+	// Pebble configures the engine; eg its memtable size(MemTableSize), and compaction settings such as
+	// L0CompactionThreshold, LBaseMaxBytes and MaxConcurrentCompactions. It defaults to pebble's defaults.
+	Pebble *pebble.Options
+}
+
+// Store keeps its keys in a pebble database.
+type Store struct {
+	db           *pebble.DB
+	writeOptions *pebble.WriteOptions
+}
+
+// Open opens, or creates, the pebble database in dir.
+//
+//	store, err := pebbleStore.Open("/var/lib/kshaka", pebbleStore.Options{})
+//	defer store.Close()
+//	node := kshaka.NewNode(1, store)
+func Open(dir string, opts Options) (*Store, error) {
+	db, err := pebble.Open(dir, opts.Pebble)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to open database:%v", dir))
+	}
+	s := &Store{db: db, writeOptions: pebble.Sync}
+	if opts.NoSync {
+		s.writeOptions = pebble.NoSync
+	}
+	return s, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Set implements the kshaka.StableStore interface.
+func (s *Store) Set(key []byte, val []byte) error {
+	return s.db.Set(key, val, s.writeOptions)
+}
+
+// Get implements the kshaka.StableStore interface.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	return get(s.db, key)
+}
+
+// SetUint64 implements the kshaka.StableStore interface.
+func (s *Store) SetUint64(key []byte, val uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, val)
+	return s.Set(key, buf)
+}
+
+// GetUint64 implements the kshaka.StableStore interface.
+func (s *Store) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("value of key:%v is %v bytes, not a uint64", key, len(val))
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// Delete implements the kshaka.DeleteStore interface.
+func (s *Store) Delete(key []byte) error {
+	return s.db.Delete(key, s.writeOptions)
+}
+
+// IteratePrefix implements the kshaka.KeyIterator interface.
+// The keys are visited in order, from a consistent view of the database.
+func (s *Store) IteratePrefix(prefix []byte, fn func(key []byte, val []byte) error) error {
+	iter, err := s.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: kshaka.PrefixEnd(prefix)})
+	if err != nil {
+		return err
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		if err = fn(append([]byte{}, iter.Key()...), append([]byte{}, iter.Value()...)); err != nil {
+			break
+		}
+	}
+	if cerr := iter.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Begin implements the kshaka.TxnStore interface.
+// The transaction is an indexed batch; Get sees the writes of the batch, and Commit applies them atomically.
+// Batches are not isolated from each other, which kshaka does not need; a node only runs one transaction per key at a time.
+func (s *Store) Begin() (kshaka.Txn, error) {
+	return &txn{batch: s.db.NewIndexedBatch(), writeOptions: s.writeOptions}, nil
+}
+
+// txn is a pebble indexed batch.
+type txn struct {
+	batch        *pebble.Batch
+	writeOptions *pebble.WriteOptions
+	done         bool
+}
+
+func (t *txn) Get(key []byte) ([]byte, error) {
+	return get(t.batch, key)
+}
+
+func (t *txn) Set(key []byte, val []byte) error {
+	return t.batch.Set(key, val, nil)
+}
+
+func (t *txn) Delete(key []byte) error {
+	return t.batch.Delete(key, nil)
+}
+
+func (t *txn) Commit() error {
+	if t.done {
+		return errors.New("the transaction has already been committed or rolled back")
+	}
+	t.done = true
+	err := t.batch.Commit(t.writeOptions)
+	if cerr := t.batch.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (t *txn) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	return t.batch.Close()
+}
+
+// get returns a copy of the value of key in r.
+// Values returned by pebble are only valid until their io.Closer is closed.
+func get(r pebble.Reader, key []byte) ([]byte, error) {
+	val, closer, err := r.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, errors.Wrap(kshaka.ErrNotFound, fmt.Sprintf("key:%v", key))
+	}
+	if err != nil {
+		return nil, err
+	}
+	val = append([]byte{}, val...)
+	return val, closer.Close()
+}
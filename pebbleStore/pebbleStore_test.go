@@ -0,0 +1,189 @@
+//go:build pebble
+// +build pebble
+
+package pebbleStore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/komuw/kshaka"
+	"github.com/komuw/kshaka/boltStore"
+)
+
+func tempDir(tb testing.TB) (string, func()) {
+	dir, err := ioutil.TempDir("", "pebbleStore")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) } // nolint: errcheck
+}
+
+func TestStore(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	store, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("foo")
+
+	if _, err := store.Get(key); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nstore.Get() \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+	if err := store.SetUint64(key, 7); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetUint64(key)
+	if err != nil || got != 7 {
+		t.Errorf("\nstore.GetUint64() \ngot= %v %v, \nwant = %v", got, err, 7)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nstore.Get() \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+
+	txn, err := store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	val, err := txn.Get(key)
+	if err != nil || string(val) != "bar" {
+		t.Errorf("\ntxn.Get() \ngot= %s %v, \nwant = %s", val, err, "bar")
+	}
+	if _, err := store.Get(key); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nstore.Get() before Commit \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Errorf("\ntxn.Rollback() after Commit \nerr = %v", err)
+	}
+
+	for _, k := range []string{"a/2", "a/1", "b/1"} {
+		if err := store.Set([]byte(k), []byte("val")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := []string{}
+	err = store.IteratePrefix([]byte("a/"), func(k []byte, val []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/1", "a/2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("\nstore.IteratePrefix() \ngot= %v, \nwant = %v", keys, want)
+	}
+
+	// the keys survive a restart.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close() // nolint: errcheck
+	val, err = store.Get(key)
+	if err != nil || string(val) != "bar" {
+		t.Errorf("\nstore.Get() after a restart \ngot= %s %v, \nwant = %s", val, err, "bar")
+	}
+}
+
+func TestPropose(t *testing.T) {
+	nodes := []*kshaka.Node{}
+	for i := uint64(1); i <= 3; i++ {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		store, err := Open(dir, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close() // nolint: errcheck
+		n := kshaka.NewNode(i, store)
+		n.AddTransport(&kshaka.InmemTransport{Node: n})
+		nodes = append(nodes, n)
+	}
+	kshaka.MingleNodes(nodes...)
+
+	key, val := []byte("foo"), []byte("bar")
+	_, err := nodes[0].Propose(key, func(current []byte) ([]byte, error) { return val, nil })
+	if err != nil {
+		t.Fatalf("\nnode.Propose() \nerr = %v", err)
+	}
+	newState, err := nodes[1].Read(key)
+	if err != nil {
+		t.Fatalf("\nnode.Read() \nerr = %v", err)
+	}
+	if string(newState) != string(val) {
+		t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", newState, val)
+	}
+}
+
+// BenchmarkAcceptor runs the writes of an acceptor, a Prepare and then an Accept for a key, against each of the stores.
+func BenchmarkAcceptor(b *testing.B) {
+	stores := []struct {
+		name string
+		open func(dir string) (kshaka.StableStore, func() error, error)
+	}{
+		{name: "inmem", open: func(dir string) (kshaka.StableStore, func() error, error) {
+			return &kshaka.InmemStore{}, func() error { return nil }, nil
+		}},
+		{name: "bolt", open: func(dir string) (kshaka.StableStore, func() error, error) {
+			s, err := boltStore.Open(filepath.Join(dir, "kshaka.db"), boltStore.Options{})
+			if err != nil {
+				return nil, nil, err
+			}
+			return s, s.Close, nil
+		}},
+		{name: "pebble", open: func(dir string) (kshaka.StableStore, func() error, error) {
+			s, err := Open(dir, Options{})
+			if err != nil {
+				return nil, nil, err
+			}
+			return s, s.Close, nil
+		}},
+	}
+	state := bytes.Repeat([]byte("v"), 100)
+	for _, s := range stores {
+		for _, keys := range []int{1, 1000} {
+			b.Run(fmt.Sprintf("%v/keys=%v", s.name, keys), func(b *testing.B) {
+				dir, cleanup := tempDir(b)
+				defer cleanup()
+				store, closeStore, err := s.open(dir)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer closeStore() // nolint: errcheck
+				n := kshaka.NewNode(1, store)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					key := []byte(fmt.Sprintf("key-%v", i%keys))
+					ballot := kshaka.Ballot{Counter: uint64(i + 1), NodeID: 1}
+					if _, err := n.Prepare(ballot, key); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := n.Accept(ballot, key, state); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -1,9 +1,24 @@
 package kshaka
 
+import (
+	"context"
+)
+
+// ballotCounterLease is the number of Ballot counters that a proposer reserves each time it persists its counter.
+const ballotCounterLease = 1000
+
+// ballotCounterMetaKey is the key, in the meta namespace, that we use to store the Ballot counter reserved by a proposer.
+var ballotCounterMetaKey = []byte("ballotCounter")
+
+// ballotCounterKey is the key that we used to store the Ballot counter reserved by a proposer, before it was kept in the meta namespace.
+// It is only read to migrate old stores.
+// it ought to be unique and clients/users will be prohibited from using this value as a key for their data.
+var ballotCounterKey = []byte("__COUNTER__Ballot__KEY__bbdaf580-c99f-11f1-8577-02fc00000001__bbdaf6a2-c99f-11f1-8577-02fc00000001")
+
 // Proposer perform the initialization by communicating with acceptors.
 // Proposers keep minimal state needed to generate unique increasing update IDs (Ballot numbers),
 // the system may have arbitrary numbers of proposers.
 type proposer interface {
-	sendPrepare(key []byte) ([]byte, error)
-	sendAccept(key []byte, currentState []byte, changeFunc ChangeFunction) ([]byte, error)
+	sendPrepare(ctx context.Context, key []byte) (Ballot, []byte, error)
+	sendAccept(ctx context.Context, key []byte, b Ballot, currentState []byte, changeFunc ChangeFunction) ([]byte, error)
 }
@@ -1,9 +1,18 @@
 package kshaka
 
+import (
+	"context"
+)
+
 // ProposerAcceptor is an entity that is both a proposer and an acceptor.
 type ProposerAcceptor interface {
 	proposer
 	acceptor
 	Propose(key []byte, changeFunc ChangeFunction) ([]byte, error)
+	ProposeContext(ctx context.Context, key []byte, changeFunc ChangeFunction) ([]byte, error)
+	Read(key []byte) ([]byte, error)
+	ReadContext(ctx context.Context, key []byte) ([]byte, error)
+	Delete(key []byte) error
+	DeleteContext(ctx context.Context, key []byte) error
 	AddTransport(t Transport)
 }
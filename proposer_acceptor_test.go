@@ -1,8 +1,11 @@
 package kshaka
 
 import (
+	"context"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPropose(t *testing.T) {
@@ -110,3 +113,140 @@ func TestPropose(t *testing.T) {
 		})
 	}
 }
+
+// hungTransport is a Transport whose calls never complete until their context is done.
+type hungTransport struct{}
+
+func (ht *hungTransport) TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error) {
+	<-ctx.Done()
+	return AcceptorState{}, ctx.Err()
+}
+
+func (ht *hungTransport) TransportAccept(ctx context.Context, b Ballot, key []byte, state []byte) (AcceptorState, error) {
+	<-ctx.Done()
+	return AcceptorState{}, ctx.Err()
+}
+
+func TestProposeContext(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+
+	tests := []struct {
+		name    string
+		trans   func(n *Node) Transport
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		{name: "cancelled context",
+			trans: func(n *Node) Transport { return &InmemTransport{Node: n} },
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			wantErr: context.Canceled,
+		},
+		{name: "hung acceptors",
+			trans: func(n *Node) Transport { return &hungTransport{} },
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{name: "healthy acceptors",
+			trans: func(n *Node) Transport { return &InmemTransport{Node: n} },
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 5*time.Second)
+			},
+			wantErr: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := []*Node{}
+			for i := uint64(1); i <= 3; i++ {
+				n := NewNode(i, &InmemStore{kv: map[string][]byte{}})
+				n.AddTransport(tt.trans(n))
+				nodes = append(nodes, n)
+			}
+			MingleNodes(nodes...)
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			_, err := nodes[0].ProposeContext(ctx, []byte("foo"), setFunc([]byte("bar")))
+			if err != tt.wantErr {
+				t.Errorf("\nnode.ProposeContext() \nerror = %v, \nwantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProposeRetry(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+
+	tests := []struct {
+		name        string
+		policy      RetryPolicy
+		want        []byte
+		wantErr     bool
+		errContains string
+	}{
+		{name: "no retries",
+			policy:      RetryPolicy{},
+			want:        nil,
+			wantErr:     true,
+			errContains: "after 1 attempt(s)",
+		},
+		{name: "retry after conflict",
+			policy:  RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Jitter: 0.5},
+			want:    []byte("bar"),
+			wantErr: false,
+		},
+		{name: "retry budget exhausted",
+			policy:      RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, Budget: time.Millisecond},
+			want:        nil,
+			wantErr:     true,
+			errContains: "retry budget",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := []*Node{}
+			for i := uint64(1); i <= 3; i++ {
+				n := NewNode(i, &InmemStore{kv: map[string][]byte{}})
+				n.AddTransport(&InmemTransport{Node: n})
+				nodes = append(nodes, n)
+			}
+			MingleNodes(nodes...)
+
+			// another proposer has already been promised a higher Ballot by every acceptor.
+			for _, n := range nodes {
+				_, err := n.Prepare(Ballot{Counter: 5, NodeID: 9}, []byte("foo"))
+				if err != nil {
+					t.Fatalf("unable to prepare: %v", err)
+				}
+			}
+
+			nodes[0].AddRetryPolicy(tt.policy)
+			newstate, err := nodes[0].Propose([]byte("foo"), setFunc([]byte("bar")))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("\nnode.Propose() \nerror = %v, \nwantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("\nnode.Propose() \nerror = %v, \nwanted it to contain %v", err, tt.errContains)
+			}
+			if !reflect.DeepEqual(newstate, tt.want) {
+				t.Errorf("\nnode.Propose() \ngot= %v, \nwant = %v", newstate, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package kshaka
+
+import (
+	"fmt"
+)
+
+// Phase is one of the two phases of the CASPaxos protocol.
+type Phase int
+
+const (
+	// PreparePhase is the phase in which a proposer collects promises and the current state from the acceptors.
+	PreparePhase Phase = iota
+	// AcceptPhase is the phase in which a proposer gets the new state accepted by the acceptors.
+	AcceptPhase
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PreparePhase:
+		return "prepare"
+	case AcceptPhase:
+		return "accept"
+	}
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// QuorumSystem decides which sets of acceptors make up a quorum in each phase.
+// A Node consults its QuorumSystem to know when it has heard back from enough acceptors; see Node.AddQuorumSystem
+//
+// For CASPaxos to be safe, every prepare quorum has to intersect with every accept quorum.
+// Prepare quorums do not need to intersect with each other, nor do accept quorums.
+// Prepare and accept messages usually go to the same acceptors, but not while the membership of the cluster is changing.
+type QuorumSystem interface {
+	// IsQuorum reports whether the acceptors that confirmed are a quorum for phase,
+	// where acceptors are all the acceptors that the messages of that phase were sent to.
+	IsQuorum(phase Phase, acceptors []*Node, confirmed []*Node) bool
+	// Validate returns an error if some prepare quorum, out of prepareAcceptors,
+	// might not intersect with some accept quorum, out of acceptAcceptors.
+	Validate(prepareAcceptors []*Node, acceptAcceptors []*Node) error
+	// PrepareQuorumsIntersect reports whether, out of prepareAcceptors, every prepare quorum is also an accept quorum
+	// or any two prepare quorums intersect. Node.Read only skips the accept phase when it does.
+	PrepareQuorumsIntersect(prepareAcceptors []*Node) bool
+}
+
+// quorumsIntersect reports whether any set of acceptors holding at least prepareVotes of the votes
+// intersects with any set holding at least acceptVotes, when all the acceptors together hold totalVotes.
+func quorumsIntersect(prepareVotes int, acceptVotes int, totalVotes int) bool {
+	return prepareVotes+acceptVotes > totalVotes
+}
+
+// Majority is the QuorumSystem in which a quorum is any majority of the acceptors, in both phases.
+// This is the default for a Node, it tolerates the failure of F out of 2F+1 acceptors.
+type Majority struct{}
+
+// IsQuorum implements the QuorumSystem interface.
+func (m Majority) IsQuorum(phase Phase, acceptors []*Node, confirmed []*Node) bool {
+	return len(confirmed) >= len(acceptors)/2+1
+}
+
+// Validate implements the QuorumSystem interface.
+func (m Majority) Validate(prepareAcceptors []*Node, acceptAcceptors []*Node) error {
+	all := removeDuplicatesNodes(append(append([]*Node{}, prepareAcceptors...), acceptAcceptors...))
+	if !quorumsIntersect(len(prepareAcceptors)/2+1, len(acceptAcceptors)/2+1, len(all)) {
+		return fmt.Errorf("a majority of the %v prepare acceptors might not intersect with a majority of the %v accept acceptors", len(prepareAcceptors), len(acceptAcceptors))
+	}
+	return nil
+}
+
+// PrepareQuorumsIntersect implements the QuorumSystem interface.
+func (m Majority) PrepareQuorumsIntersect(prepareAcceptors []*Node) bool {
+	return true
+}
+
+// Flexible is the QuorumSystem of Flexible Paxos, in which prepare and accept quorums have different sizes.
+// A smaller AcceptQuorum makes writes faster, at the cost of a larger PrepareQuorum;
+// which makes the cluster unavailable sooner when acceptors fail. eg with 5 acceptors,
+// a PrepareQuorum of 4 and an AcceptQuorum of 2 still work when 3 acceptors fail, as long as no prepare phase is needed.
+// PrepareQuorum+AcceptQuorum has to be greater than the number of acceptors.
+// Unless PrepareQuorum is at least AcceptQuorum, or a majority of the acceptors, Node.Read writes back every value that it reads.
+type Flexible struct {
+	PrepareQuorum int
+	AcceptQuorum  int
+}
+
+// IsQuorum implements the QuorumSystem interface.
+func (f Flexible) IsQuorum(phase Phase, acceptors []*Node, confirmed []*Node) bool {
+	if phase == PreparePhase {
+		return len(confirmed) >= f.PrepareQuorum
+	}
+	return len(confirmed) >= f.AcceptQuorum
+}
+
+// Validate implements the QuorumSystem interface.
+func (f Flexible) Validate(prepareAcceptors []*Node, acceptAcceptors []*Node) error {
+	if f.PrepareQuorum < 1 || f.AcceptQuorum < 1 {
+		return fmt.Errorf("prepare quorum:%v and accept quorum:%v should both be at least 1", f.PrepareQuorum, f.AcceptQuorum)
+	}
+	all := removeDuplicatesNodes(append(append([]*Node{}, prepareAcceptors...), acceptAcceptors...))
+	if !quorumsIntersect(f.PrepareQuorum, f.AcceptQuorum, len(all)) {
+		return fmt.Errorf("prepare quorum:%v plus accept quorum:%v should be greater than the number of acceptors:%v", f.PrepareQuorum, f.AcceptQuorum, len(all))
+	}
+	return nil
+}
+
+// PrepareQuorumsIntersect implements the QuorumSystem interface.
+func (f Flexible) PrepareQuorumsIntersect(prepareAcceptors []*Node) bool {
+	return f.PrepareQuorum >= f.AcceptQuorum || quorumsIntersect(f.PrepareQuorum, f.PrepareQuorum, len(prepareAcceptors))
+}
+
+// Weighted is the QuorumSystem in which each acceptor has a number of votes, and a quorum is any set of acceptors
+// that holds more than half of the votes of all the acceptors, in both phases.
+// Weights maps the ID of an acceptor to its votes; acceptors that are not in Weights have one vote.
+type Weighted struct {
+	Weights map[uint64]int
+}
+
+func (w Weighted) votes(acceptors []*Node) int {
+	votes := 0
+	for _, a := range acceptors {
+		weight, ok := w.Weights[a.ID]
+		if !ok {
+			weight = 1
+		}
+		votes = votes + weight
+	}
+	return votes
+}
+
+// IsQuorum implements the QuorumSystem interface.
+func (w Weighted) IsQuorum(phase Phase, acceptors []*Node, confirmed []*Node) bool {
+	return w.votes(confirmed) >= w.votes(acceptors)/2+1
+}
+
+// Validate implements the QuorumSystem interface.
+func (w Weighted) Validate(prepareAcceptors []*Node, acceptAcceptors []*Node) error {
+	for ID, weight := range w.Weights {
+		if weight < 0 {
+			return fmt.Errorf("acceptor:%v has a negative weight:%v", ID, weight)
+		}
+	}
+	prepareVotes, acceptVotes := w.votes(prepareAcceptors), w.votes(acceptAcceptors)
+	if prepareVotes < 1 || acceptVotes < 1 {
+		return fmt.Errorf("prepare acceptors with %v votes and accept acceptors with %v votes should both have votes", prepareVotes, acceptVotes)
+	}
+	all := removeDuplicatesNodes(append(append([]*Node{}, prepareAcceptors...), acceptAcceptors...))
+	if !quorumsIntersect(prepareVotes/2+1, acceptVotes/2+1, w.votes(all)) {
+		return fmt.Errorf("a majority of the %v votes of the prepare acceptors might not intersect with a majority of the %v votes of the accept acceptors", prepareVotes, acceptVotes)
+	}
+	return nil
+}
+
+// PrepareQuorumsIntersect implements the QuorumSystem interface.
+func (w Weighted) PrepareQuorumsIntersect(prepareAcceptors []*Node) bool {
+	return true
+}
+
+// defaultZoneLabel is the Node.Metadata label that ZoneAware reads the zone of an acceptor from, unless told otherwise.
+const defaultZoneLabel = "zone"
+
+// ZoneAware is a QuorumSystem that, on top of the quorums of Base, requires every quorum to span at least MinZones zones.
+// The zone of an acceptor is the value of the Label in its Node.Metadata; eg node.AddMetadata(map[string]string{"zone": "us-east-1a"})
+// Acceptors without the label are all in the same, unnamed, zone.
+// Quorums tend to be made up of the closest acceptors, since they reply first; and only of them if the proposer sends to the fastest quorum
+// first(see Node.EnableFastestQuorums). MinZones makes sure that an accepted value survives the loss of any MinZones-1 zones.
+type ZoneAware struct {
+	// Base decides the size of the quorums, nil means a Majority.
+	Base QuorumSystem
+	// Label is the Node.Metadata label that holds the zone of an acceptor, empty means "zone".
+	Label    string
+	MinZones int
+}
+
+func (z ZoneAware) base() QuorumSystem {
+	if z.Base == nil {
+		return Majority{}
+	}
+	return z.Base
+}
+
+func (z ZoneAware) zones(acceptors []*Node) int {
+	label := z.Label
+	if label == "" {
+		label = defaultZoneLabel
+	}
+	zones := map[string]bool{}
+	for _, a := range acceptors {
+		zones[a.Metadata[label]] = true
+	}
+	return len(zones)
+}
+
+// IsQuorum implements the QuorumSystem interface.
+func (z ZoneAware) IsQuorum(phase Phase, acceptors []*Node, confirmed []*Node) bool {
+	return z.base().IsQuorum(phase, acceptors, confirmed) && z.zones(confirmed) >= z.MinZones
+}
+
+// Validate implements the QuorumSystem interface.
+func (z ZoneAware) Validate(prepareAcceptors []*Node, acceptAcceptors []*Node) error {
+	err := z.base().Validate(prepareAcceptors, acceptAcceptors)
+	if err != nil {
+		return err
+	}
+	if zones := z.zones(prepareAcceptors); zones < z.MinZones {
+		return fmt.Errorf("the prepare acceptors span %v zones, less than the required minimum of:%v", zones, z.MinZones)
+	}
+	if zones := z.zones(acceptAcceptors); zones < z.MinZones {
+		return fmt.Errorf("the accept acceptors span %v zones, less than the required minimum of:%v", zones, z.MinZones)
+	}
+	return nil
+}
+
+// PrepareQuorumsIntersect implements the QuorumSystem interface.
+func (z ZoneAware) PrepareQuorumsIntersect(prepareAcceptors []*Node) bool {
+	return z.base().PrepareQuorumsIntersect(prepareAcceptors)
+}
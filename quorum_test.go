@@ -0,0 +1,207 @@
+package kshaka
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// downTransport is an InmemTransport to an acceptor that may be unreachable.
+type downTransport struct {
+	InmemTransport
+	down bool
+}
+
+func (dt *downTransport) TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error) {
+	if dt.down {
+		return AcceptorState{}, errors.New("acceptor is down")
+	}
+	return dt.InmemTransport.TransportPrepare(ctx, b, key)
+}
+
+func (dt *downTransport) TransportAccept(ctx context.Context, b Ballot, key []byte, state []byte) (AcceptorState, error) {
+	if dt.down {
+		return AcceptorState{}, errors.New("acceptor is down")
+	}
+	return dt.InmemTransport.TransportAccept(ctx, b, key, state)
+}
+
+func TestQuorumSystems(t *testing.T) {
+	nodes := []*Node{}
+	for i := uint64(1); i <= 5; i++ {
+		nodes = append(nodes, &Node{ID: i})
+	}
+
+	tests := []struct {
+		name      string
+		q         QuorumSystem
+		phase     Phase
+		confirmed []*Node
+		want      bool
+	}{
+		{name: "majority", q: Majority{}, phase: PreparePhase, confirmed: nodes[:3], want: true},
+		{name: "majority minority", q: Majority{}, phase: AcceptPhase, confirmed: nodes[:2], want: false},
+		{name: "flexible prepare", q: Flexible{PrepareQuorum: 4, AcceptQuorum: 2}, phase: PreparePhase, confirmed: nodes[:3], want: false},
+		{name: "flexible accept", q: Flexible{PrepareQuorum: 4, AcceptQuorum: 2}, phase: AcceptPhase, confirmed: nodes[:2], want: true},
+		{name: "weighted heavy acceptor", q: Weighted{Weights: map[uint64]int{1: 3}}, phase: PreparePhase, confirmed: nodes[:2], want: true},
+		{name: "weighted light acceptors", q: Weighted{Weights: map[uint64]int{1: 3}}, phase: AcceptPhase, confirmed: nodes[1:4], want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.IsQuorum(tt.phase, nodes, tt.confirmed); got != tt.want {
+				t.Errorf("\nIsQuorum(%v, %v confirmations) \ngot= %v, \nwant = %v", tt.phase, len(tt.confirmed), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuorumSystemsValidate(t *testing.T) {
+	nodes := []*Node{}
+	for i := uint64(1); i <= 5; i++ {
+		nodes = append(nodes, &Node{ID: i})
+	}
+
+	tests := []struct {
+		name             string
+		q                QuorumSystem
+		prepareAcceptors []*Node
+		wantErr          bool
+	}{
+		{name: "majority", q: Majority{}, prepareAcceptors: nodes},
+		{name: "majority adding one acceptor", q: Majority{}, prepareAcceptors: nodes[:4]},
+		{name: "majority adding two acceptors", q: Majority{}, prepareAcceptors: nodes[:3], wantErr: true},
+		{name: "flexible", q: Flexible{PrepareQuorum: 4, AcceptQuorum: 2}, prepareAcceptors: nodes},
+		{name: "flexible quorums do not intersect", q: Flexible{PrepareQuorum: 3, AcceptQuorum: 2}, prepareAcceptors: nodes, wantErr: true},
+		{name: "weighted", q: Weighted{Weights: map[uint64]int{1: 3}}, prepareAcceptors: nodes},
+		{name: "weighted removing heavy acceptor", q: Weighted{Weights: map[uint64]int{5: 5}}, prepareAcceptors: nodes[:4], wantErr: true},
+		{name: "weighted negative", q: Weighted{Weights: map[uint64]int{1: -1}}, prepareAcceptors: nodes, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.q.Validate(tt.prepareAcceptors, nodes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("\nValidate() \nerr = %v, \nwantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrepareQuorumsIntersect(t *testing.T) {
+	nodes := []*Node{}
+	for i := uint64(1); i <= 5; i++ {
+		nodes = append(nodes, &Node{ID: i})
+	}
+
+	tests := []struct {
+		name string
+		q    QuorumSystem
+		want bool
+	}{
+		{name: "majority", q: Majority{}, want: true},
+		{name: "weighted", q: Weighted{Weights: map[uint64]int{1: 3}}, want: true},
+		{name: "flexible large prepare quorums", q: Flexible{PrepareQuorum: 4, AcceptQuorum: 2}, want: true},
+		{name: "flexible majority prepare quorums", q: Flexible{PrepareQuorum: 3, AcceptQuorum: 3}, want: true},
+		{name: "flexible small prepare quorums", q: Flexible{PrepareQuorum: 2, AcceptQuorum: 4}, want: false},
+		{name: "zone aware", q: ZoneAware{Base: Flexible{PrepareQuorum: 2, AcceptQuorum: 4}, MinZones: 1}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.PrepareQuorumsIntersect(nodes); got != tt.want {
+				t.Errorf("\nPrepareQuorumsIntersect() \ngot= %v, \nwant = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProposeQuorumSystem(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+
+	tests := []struct {
+		name    string
+		q       QuorumSystem
+		down    []int
+		wantErr bool
+	}{
+		{name: "majority", q: nil, down: []int{3, 4}},
+		{name: "majority unavailable", q: nil, down: []int{2, 3, 4}, wantErr: true},
+		{name: "flexible", q: Flexible{PrepareQuorum: 4, AcceptQuorum: 2}, down: []int{4}},
+		{name: "flexible unavailable", q: Flexible{PrepareQuorum: 4, AcceptQuorum: 2}, down: []int{3, 4}, wantErr: true},
+		{name: "flexible invalid", q: Flexible{PrepareQuorum: 2, AcceptQuorum: 2}, wantErr: true},
+		{name: "weighted", q: Weighted{Weights: map[uint64]int{1: 3}}, down: []int{2, 3, 4}},
+		{name: "weighted unavailable", q: Weighted{Weights: map[uint64]int{1: 3}}, down: []int{0, 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := []*Node{}
+			transports := []*downTransport{}
+			for i := uint64(1); i <= 5; i++ {
+				n := NewNode(i, &InmemStore{})
+				trans := &downTransport{InmemTransport: InmemTransport{Node: n}}
+				n.AddTransport(trans)
+				n.AddQuorumSystem(tt.q)
+				nodes = append(nodes, n)
+				transports = append(transports, trans)
+			}
+			MingleNodes(nodes...)
+			for _, i := range tt.down {
+				transports[i].down = true
+			}
+
+			_, err := nodes[1].Propose([]byte("foo"), setFunc([]byte("bar")))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("\nnode.Propose() \nerr = %v, \nwantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestZoneAwareQuorum(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+	zones := []string{"a", "a", "a", "b", "c"}
+
+	tests := []struct {
+		name    string
+		q       QuorumSystem
+		down    []int
+		wantErr bool
+	}{
+		{name: "one zone", q: ZoneAware{MinZones: 1}, down: []int{3, 4}},
+		{name: "two zones", q: ZoneAware{MinZones: 2}, down: []int{4}},
+		{name: "two zones, one zone up", q: ZoneAware{MinZones: 2}, down: []int{3, 4}, wantErr: true},
+		{name: "two zones, zone a down", q: ZoneAware{MinZones: 2}, down: []int{0, 1, 2}, wantErr: true},
+		{name: "flexible two zones", q: ZoneAware{Base: Flexible{PrepareQuorum: 4, AcceptQuorum: 2}, MinZones: 2}, down: []int{2}},
+		{name: "too many zones", q: ZoneAware{MinZones: 4}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := []*Node{}
+			transports := []*downTransport{}
+			for i, zone := range zones {
+				n := NewNode(uint64(i+1), &InmemStore{})
+				trans := &downTransport{InmemTransport: InmemTransport{Node: n}}
+				n.AddTransport(trans)
+				n.AddMetadata(map[string]string{"zone": zone})
+				n.AddQuorumSystem(tt.q)
+				nodes = append(nodes, n)
+				transports = append(transports, trans)
+			}
+			MingleNodes(nodes...)
+			for _, i := range tt.down {
+				transports[i].down = true
+			}
+
+			_, err := nodes[0].Propose([]byte("foo"), setFunc([]byte("bar")))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("\nnode.Propose() \nerr = %v, \nwantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
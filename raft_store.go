@@ -0,0 +1,51 @@
+package kshaka
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// raftNotFoundErr is the message of the errors that the hashicorp/raft stores return for keys that they do not have.
+// see: https://github.com/hashicorp/raft-boltdb/blob/6e5ba93211eaf8d9a2ad7e41ffad8c6f160f9fe3/bolt_store.go#L241-L246
+const raftNotFoundErr = "not found"
+
+// RaftStore adapts a store that implements the StableStore interface of hashicorp/raft, eg github.com/hashicorp/raft-boltdb,
+// to the StableStore contract of kshaka.
+// Those stores report missing keys with errors that only read "not found"; RaftStore returns ErrNotFound for them instead.
+//
+//	boltStore, err := raftboltdb.NewBoltStore("/tmp/bolt.db")
+//	node := kshaka.NewNode(1, &kshaka.RaftStore{Store: boltStore})
+type RaftStore struct {
+	Store StableStore
+}
+
+// Set implements the StableStore interface.
+func (r *RaftStore) Set(key []byte, val []byte) error {
+	return r.Store.Set(key, val)
+}
+
+// Get implements the StableStore interface.
+func (r *RaftStore) Get(key []byte) ([]byte, error) {
+	val, err := r.Store.Get(key)
+	return val, r.notFound(key, err)
+}
+
+// SetUint64 implements the StableStore interface.
+func (r *RaftStore) SetUint64(key []byte, val uint64) error {
+	return r.Store.SetUint64(key, val)
+}
+
+// GetUint64 implements the StableStore interface.
+func (r *RaftStore) GetUint64(key []byte) (uint64, error) {
+	val, err := r.Store.GetUint64(key)
+	return val, r.notFound(key, err)
+}
+
+// notFound converts the not found errors of the hashicorp/raft stores to ErrNotFound.
+func (r *RaftStore) notFound(key []byte, err error) error {
+	if err != nil && err.Error() == raftNotFoundErr {
+		return errors.Wrap(ErrNotFound, fmt.Sprintf("key:%v", key))
+	}
+	return err
+}
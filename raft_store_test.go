@@ -0,0 +1,108 @@
+package kshaka
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// raftLikeStore behaves like the stores of hashicorp/raft; missing keys are reported with an error that reads "not found".
+type raftLikeStore struct {
+	l  sync.Mutex
+	kv map[string][]byte
+}
+
+func (r *raftLikeStore) Set(key []byte, val []byte) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if r.kv == nil {
+		r.kv = map[string][]byte{}
+	}
+	r.kv[string(key)] = val
+	return nil
+}
+
+func (r *raftLikeStore) Get(key []byte) ([]byte, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	val, ok := r.kv[string(key)]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return val, nil
+}
+
+func (r *raftLikeStore) SetUint64(key []byte, val uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, val)
+	return r.Set(key, buf)
+}
+
+func (r *raftLikeStore) GetUint64(key []byte) (uint64, error) {
+	val, err := r.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// brokenStore is a StableStore whose reads fail.
+// It only exposes the StableStore methods of the store it wraps, so that no read can go around Get.
+type brokenStore struct {
+	StableStore
+}
+
+func (b *brokenStore) Get(key []byte) ([]byte, error) {
+	return nil, errors.New("disk I/O error")
+}
+
+func TestRaftStore(t *testing.T) {
+	t.Run("adapted stores report ErrNotFound", func(t *testing.T) {
+		store := &RaftStore{Store: &raftLikeStore{}}
+		_, err := store.Get([]byte("foo"))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("\nRaftStore.Get() \ngot= %v, \nwant = %v", err, ErrNotFound)
+		}
+		_, err = store.GetUint64([]byte("foo"))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("\nRaftStore.GetUint64() \ngot= %v, \nwant = %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("propose", func(t *testing.T) {
+		nodes := []*Node{}
+		for i := uint64(1); i <= 3; i++ {
+			n := NewNode(i, &RaftStore{Store: &raftLikeStore{}})
+			n.AddTransport(&InmemTransport{Node: n})
+			nodes = append(nodes, n)
+		}
+		MingleNodes(nodes...)
+		key, val := []byte("foo"), []byte("bar")
+		_, err := nodes[0].Propose(key, func(current []byte) ([]byte, error) { return val, nil })
+		if err != nil {
+			t.Fatalf("\nnode.Propose() \nerr = %v", err)
+		}
+		newState, err := nodes[1].Read(key)
+		if err != nil {
+			t.Fatalf("\nnode.Read() \nerr = %v", err)
+		}
+		if string(newState) != string(val) {
+			t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", newState, val)
+		}
+	})
+
+	t.Run("read errors are not taken as missing keys", func(t *testing.T) {
+		n := NewNode(1, &brokenStore{StableStore: &InmemStore{}})
+		_, err := n.Prepare(Ballot{Counter: 1, NodeID: 2}, []byte("foo"))
+		if err == nil {
+			t.Errorf("\nnode.Prepare() \nwanted the error of the store")
+		}
+		// a hashicorp/raft store that is not adapted fails, rather than being read as empty.
+		n = NewNode(1, &raftLikeStore{})
+		_, err = n.Prepare(Ballot{Counter: 1, NodeID: 2}, []byte("foo"))
+		if err == nil {
+			t.Errorf("\nnode.Prepare() \nwanted an error for the store's not found error")
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package kshaka
+
+import (
+	"context"
+)
+
+// identityFunc is the ChangeFunction that leaves the current state as it is.
+var identityFunc ChangeFunction = func(current []byte) ([]byte, error) {
+	return current, nil
+}
+
+// Read returns the current value stored at key.
+// It is a linearizable read that, unlike proposing a ChangeFunction that returns the current state,
+// usually only takes the prepare phase:
+// if all the acceptors in the quorum reply with the same accepted Ballot, their value is returned without writing it again.
+// Only when the replies disagree, or the node's QuorumSystem does not allow skipping it(see QuorumSystem.PrepareQuorumsIntersect),
+// is the value with the highest Ballot written back to the acceptors with an accept phase.
+func (n *Node) Read(key []byte) ([]byte, error) {
+	return n.ReadContext(context.Background(), key)
+}
+
+// ReadContext is like Read but it is bound by ctx, in the same way that ProposeContext is.
+func (n *Node) ReadContext(ctx context.Context, key []byte) ([]byte, error) {
+	unlock := n.lockProposals(key)
+	defer unlock()
+	return n.withRetries(ctx, n.retryPolicy, "read", func() ([]byte, error) {
+		return n.read(ctx, key)
+	})
+}
+
+// read runs a single prepare phase, followed by an accept phase if the acceptors disagree.
+// Skipping the accept phase when they agree is only safe if any two prepare quorums intersect, or every prepare quorum is an accept quorum:
+// the value that a quorum agrees on has then been accepted by a quorum, or will be seen by every later read.
+// Otherwise the value is always written back; eg with Flexible quorums whose prepare quorums are small.
+func (n *Node) read(ctx context.Context, key []byte) ([]byte, error) {
+	// the prepare phase supersedes any Ballot that the acceptors promised for our next write on key.
+	n.takePrepared(key)
+
+	ballot, currentState, agreement, err := n.sendPrepareAgreement(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if agreement && n.fastReads() {
+		// a quorum has accepted the same Ballot, so its value is the current one.
+		return currentState, nil
+	}
+
+	// write back the value with the highest Ballot, so that the next reads agree on it.
+	return n.sendAccept(ctx, key, ballot, currentState, identityFunc)
+}
+
+// fastReads reports whether the node's QuorumSystem lets a read skip the accept phase when a prepare quorum agrees.
+func (n *Node) fastReads() bool {
+	quorum, err := n.quorum()
+	if err != nil {
+		return false
+	}
+	return quorum.PrepareQuorumsIntersect(n.prepareAcceptors())
+}
@@ -0,0 +1,195 @@
+package kshaka
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// acceptCountingTransport is an InmemTransport that counts the accept messages it delivers.
+type acceptCountingTransport struct {
+	InmemTransport
+	accepts *int64
+}
+
+func (at *acceptCountingTransport) TransportAccept(ctx context.Context, b Ballot, key []byte, state []byte) (AcceptorState, error) {
+	atomic.AddInt64(at.accepts, 1)
+	return at.InmemTransport.TransportAccept(ctx, b, key, state)
+}
+
+func TestRead(t *testing.T) {
+	var setFunc = func(val []byte) ChangeFunction {
+		return func(current []byte) ([]byte, error) {
+			return val, nil
+		}
+	}
+
+	newCluster := func() ([]*Node, *int64) {
+		var accepts int64
+		nodes := []*Node{}
+		for i := uint64(1); i <= 3; i++ {
+			n := NewNode(i, &InmemStore{})
+			n.AddTransport(&acceptCountingTransport{InmemTransport: InmemTransport{Node: n}, accepts: &accepts})
+			nodes = append(nodes, n)
+		}
+		MingleNodes(nodes...)
+		return nodes, &accepts
+	}
+	key := []byte("foo")
+
+	t.Run("key not set", func(t *testing.T) {
+		nodes, accepts := newCluster()
+		val, err := nodes[0].Read(key)
+		if err != nil {
+			t.Fatalf("\nnode.Read() \nerr = %v", err)
+		}
+		if val != nil {
+			t.Errorf("\nnode.Read() \ngot= %v, \nwant = %v", val, nil)
+		}
+		if got := atomic.LoadInt64(accepts); got != 0 {
+			t.Errorf("\nnode.Read() \naccept messages = %v, \nwant = %v", got, 0)
+		}
+	})
+
+	t.Run("acceptors agree", func(t *testing.T) {
+		nodes, accepts := newCluster()
+		// every acceptor accepts the same Ballot.
+		for _, a := range nodes {
+			if _, err := a.Accept(Ballot{Counter: 1, NodeID: 3}, key, []byte("bar")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes[0].Ballot.Counter = 10
+		val, err := nodes[0].Read(key)
+		if err != nil {
+			t.Fatalf("\nnode.Read() \nerr = %v", err)
+		}
+		if !reflect.DeepEqual(val, []byte("bar")) {
+			t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", val, "bar")
+		}
+		if got := atomic.LoadInt64(accepts); got != 0 {
+			t.Errorf("\nnode.Read() \naccept messages = %v, \nwant = %v", got, 0)
+		}
+	})
+
+	t.Run("acceptors disagree", func(t *testing.T) {
+		nodes, accepts := newCluster()
+		// every acceptor has accepted a different Ballot, so any quorum disagrees.
+		for i, val := range []string{"a", "b", "c"} {
+			if _, err := nodes[i].Accept(Ballot{Counter: uint64(i + 1), NodeID: 3}, key, []byte(val)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes[0].Ballot.Counter = 10
+		val, err := nodes[0].Read(key)
+		if err != nil {
+			t.Fatalf("\nnode.Read() \nerr = %v", err)
+		}
+		if string(val) != "b" && string(val) != "c" {
+			t.Errorf("\nnode.Read() \ngot= %s, \nwant = %v", val, "b or c")
+		}
+		if got := atomic.LoadInt64(accepts); got == 0 {
+			t.Errorf("\nnode.Read() \naccept messages = %v, \nwanted the value to be written back", got)
+		}
+
+		nodes[1].Ballot.Counter = 20
+		again, err := nodes[1].Read(key)
+		if err != nil {
+			t.Fatalf("\nnode.Read() \nerr = %v", err)
+		}
+		if !reflect.DeepEqual(again, val) {
+			t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", again, val)
+		}
+	})
+
+	t.Run("read after propose", func(t *testing.T) {
+		nodes, _ := newCluster()
+		if _, err := nodes[0].Propose(key, setFunc([]byte("bar"))); err != nil {
+			t.Fatal(err)
+		}
+		val, err := nodes[1].Read(key)
+		if err != nil {
+			t.Fatalf("\nnode.Read() \nerr = %v", err)
+		}
+		if !reflect.DeepEqual(val, []byte("bar")) {
+			t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", val, "bar")
+		}
+	})
+
+	t.Run("prepare quorums do not intersect", func(t *testing.T) {
+		var accepts int64
+		nodes := []*Node{}
+		slow := []*int32{}
+		for i := uint64(1); i <= 5; i++ {
+			n := NewNode(i, &InmemStore{})
+			var delayed int32
+			n.AddTransport(&slowPrepareTransport{
+				acceptCountingTransport: acceptCountingTransport{InmemTransport: InmemTransport{Node: n}, accepts: &accepts},
+				slow:                    &delayed})
+			// two prepare quorums of 2 out of 5 acceptors need not intersect.
+			n.AddQuorumSystem(Flexible{PrepareQuorum: 2, AcceptQuorum: 4})
+			nodes = append(nodes, n)
+			slow = append(slow, &delayed)
+		}
+		MingleNodes(nodes...)
+		// neither value has been accepted by an accept quorum, yet a prepare quorum can agree on either.
+		for i, a := range nodes {
+			b, val := Ballot{Counter: 10, NodeID: 9}, "X"
+			if i >= 2 {
+				b, val = Ballot{Counter: 5, NodeID: 9}, "Y"
+			}
+			if _, err := a.Accept(b, key, []byte(val)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		setSlow := func(from, to int) {
+			for i := range slow {
+				delayed := int32(0)
+				if i >= from && i < to {
+					delayed = 1
+				}
+				atomic.StoreInt32(slow[i], delayed)
+			}
+		}
+
+		// the first read hears from acceptors 3-5, which agree on Y.
+		setSlow(0, 2)
+		nodes[0].Ballot.Counter = 20
+		val, err := nodes[0].Read(key)
+		if err != nil {
+			t.Fatalf("\nnode.Read() \nerr = %v", err)
+		}
+		if !reflect.DeepEqual(val, []byte("Y")) {
+			t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", val, "Y")
+		}
+		if got := atomic.LoadInt64(&accepts); got == 0 {
+			t.Errorf("\nnode.Read() \naccept messages = %v, \nwanted the value to be written back", got)
+		}
+
+		// the next read hears from acceptors 1-2, which agreed on X before the first read.
+		setSlow(2, 5)
+		nodes[1].Ballot.Counter = 40
+		again, err := nodes[1].Read(key)
+		if err != nil {
+			t.Fatalf("\nnode.Read() \nerr = %v", err)
+		}
+		if !reflect.DeepEqual(again, val) {
+			t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", again, val)
+		}
+	})
+}
+
+// slowPrepareTransport is an acceptCountingTransport that delays prepare messages while slow is set.
+type slowPrepareTransport struct {
+	acceptCountingTransport
+	slow *int32
+}
+
+func (st *slowPrepareTransport) TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error) {
+	if atomic.LoadInt32(st.slow) == 1 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return st.acceptCountingTransport.TransportPrepare(ctx, b, key)
+}
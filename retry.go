@@ -0,0 +1,54 @@
+package kshaka
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how a Node retries a proposal that failed because acceptors replied with conflicts.
+// Each retry re-runs both the prepare and accept phases with a Ballot that has been fast-forwarded past the conflicts.
+// The zero value disables retries; which is the default for a Node.
+// A proposal that failed only because messages could not be delivered to the acceptors is not retried.
+//
+// Note that a ChangeFunction may be applied more than once when a proposal is retried,
+// since an accept that failed to reach a quorum may still have been accepted by some acceptors.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of prepare/accept cycles, including the first one.
+	MaxAttempts int
+	// InitialBackoff is how long to wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponentially growing backoff. Zero means no cap.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the backoff grows after each retry. Values less than 1 are treated as 2.
+	Multiplier float64
+	// Jitter is the fraction, between 0 and 1, of each backoff that is randomized.
+	// It keeps proposers that conflicted with each other from retrying in lockstep.
+	Jitter float64
+	// Budget is the total time that a single proposal may spend backing off across all its retries.
+	// Zero means no budget.
+	Budget time.Duration
+}
+
+// backoff returns how long to wait before the given retry; retry starts at 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		d = d * multiplier
+		if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+			d = float64(p.MaxBackoff)
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d = d - (d * jitter * rand.Float64())
+	}
+	return time.Duration(d)
+}
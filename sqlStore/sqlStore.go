@@ -0,0 +1,541 @@
+/*
+Package sqlStore provides a kshaka StableStore that keeps the state of an acceptor in a relational database, through database/sql.
+
+The store implements kshaka.BucketStore. The acceptor state of client keys, the data namespace of a node, is kept in the
+kshaka_acceptor_states table with a row per key:
+
+	acceptor | k | promised_counter | promised_node | promised_epoch | accepted_counter | accepted_node | accepted_epoch | value
+
+The other namespaces, eg the node's Ballot counter, are kept in the kshaka_kv table as (acceptor, bucket, k, v) rows.
+Each row belongs to the acceptor named by the name passed to Open; so several acceptors, in one or more processes, can share a database.
+The uint64 fields of the Ballots are stored as their int64 bit patterns.
+
+The store also implements kshaka.TxnStore, so each Prepare and Accept runs in one database transaction.
+Rows read in a transaction are locked with SELECT ... FOR UPDATE on databases that support it. A key that has no row yet
+is not locked by the read; its row is written with an upsert, so the last of two transactions that create it wins.
+Each acceptor should therefore only run in one process at a time.
+kshaka.KeyIterator and kshaka.DeleteStore are implemented as well.
+
+The package does not import a driver. Its SQLite tests use modernc.org/sqlite, a pure Go driver, and are only built with the sqlite build tag:
+
+	go test -tags sqlite ./sqlStore
+*/
+package sqlStore
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/komuw/kshaka"
+	"github.com/pkg/errors"
+)
+
+// Dialect holds the SQL that differs between databases.
+type Dialect struct {
+	// Schema are the statements that create the two tables, if they do not exist.
+	Schema []string
+	// Placeholder returns the placeholder of the nth, starting at 1, argument of a statement; eg "?" or "$1".
+	Placeholder func(n int) string
+	// ForUpdate is appended to the SELECTs done in a transaction, to lock the rows that they read.
+	ForUpdate string
+	// Upsert returns the statement that inserts a row of values for columns into table,
+	// or updates the row that has the same values for the primary key columns key.
+	// It defaults to onConflictUpsert, the INSERT ... ON CONFLICT DO UPDATE of SQLite and PostgreSQL.
+	Upsert func(table string, key []string, columns []string) string
+	// SerializeWrites makes the store run one write, or transaction, at a time;
+	// for databases like SQLite that lock the whole database for writes rather than rows.
+	SerializeWrites bool
+}
+
+// SQLite is the Dialect of SQLite. The database should be opened with a busy timeout and immediate transactions,
+// eg "_pragma=busy_timeout(5000)&_txlock=immediate" with modernc.org/sqlite; so that readers wait for writes to be committed,
+// and the transactions of stores that share the database wait for each other rather than fail with SQLITE_BUSY.
+var SQLite = Dialect{
+	Schema: []string{
+		`CREATE TABLE IF NOT EXISTS kshaka_acceptor_states (
+			acceptor TEXT NOT NULL,
+			k BLOB NOT NULL,
+			promised_counter INTEGER NOT NULL,
+			promised_node INTEGER NOT NULL,
+			promised_epoch INTEGER NOT NULL,
+			accepted_counter INTEGER NOT NULL,
+			accepted_node INTEGER NOT NULL,
+			accepted_epoch INTEGER NOT NULL,
+			value BLOB,
+			PRIMARY KEY (acceptor, k)
+		)`,
+		`CREATE TABLE IF NOT EXISTS kshaka_kv (
+			acceptor TEXT NOT NULL,
+			bucket TEXT NOT NULL,
+			k BLOB NOT NULL,
+			v BLOB,
+			PRIMARY KEY (acceptor, bucket, k)
+		)`,
+	},
+	Placeholder:     func(n int) string { return "?" },
+	Upsert:          onConflictUpsert,
+	SerializeWrites: true,
+}
+
+// Postgres is the Dialect of PostgreSQL.
+var Postgres = Dialect{
+	Schema: []string{
+		`CREATE TABLE IF NOT EXISTS kshaka_acceptor_states (
+			acceptor TEXT NOT NULL,
+			k BYTEA NOT NULL,
+			promised_counter BIGINT NOT NULL,
+			promised_node BIGINT NOT NULL,
+			promised_epoch BIGINT NOT NULL,
+			accepted_counter BIGINT NOT NULL,
+			accepted_node BIGINT NOT NULL,
+			accepted_epoch BIGINT NOT NULL,
+			value BYTEA,
+			PRIMARY KEY (acceptor, k)
+		)`,
+		`CREATE TABLE IF NOT EXISTS kshaka_kv (
+			acceptor TEXT NOT NULL,
+			bucket TEXT NOT NULL,
+			k BYTEA NOT NULL,
+			v BYTEA,
+			PRIMARY KEY (acceptor, bucket, k)
+		)`,
+	},
+	Placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+	ForUpdate:   " FOR UPDATE",
+	Upsert:      onConflictUpsert,
+}
+
+// onConflictUpsert is the upsert of SQLite, from version 3.24, and PostgreSQL, from version 9.5.
+func onConflictUpsert(table string, key []string, columns []string) string {
+	isKey := map[string]bool{}
+	for _, c := range key {
+		isKey[c] = true
+	}
+	updates := []string{}
+	for _, c := range columns {
+		if !isKey[c] {
+			updates = append(updates, c+" = excluded."+c)
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		table, strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "),
+		strings.Join(key, ", "), strings.Join(updates, ", "))
+}
+
+// Store keeps the keys of one acceptor in a database. Its own keyspace is the bucket called "" of the kshaka_kv table.
+type Store struct {
+	*bucket
+}
+
+// Open creates the tables in db, if they do not exist, and returns the store of the acceptor called name.
+// The caller owns db; and has to close it once the store is no longer used.
+//
+//	db, err := sql.Open("postgres", "postgres://kshaka@localhost/kshaka")
+//	store, err := sqlStore.Open(db, sqlStore.Postgres, "acceptor-1")
+//	node := kshaka.NewNode(1, store)
+func Open(db *sql.DB, dialect Dialect, name string) (*Store, error) {
+	for _, stmt := range dialect.Schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, errors.Wrap(err, "unable to create the tables")
+		}
+	}
+	if dialect.Upsert == nil {
+		dialect.Upsert = onConflictUpsert
+	}
+	d := &database{db: db, dialect: dialect}
+	return &Store{bucket: &bucket{db: d, table: newKVTable(d, name, "")}}, nil
+}
+
+// Bucket implements the kshaka.BucketStore interface.
+// kshaka.DataNamespace is kept in the kshaka_acceptor_states table, and every other bucket in the kshaka_kv table.
+func (s *Store) Bucket(name []byte) kshaka.StableStore {
+	acceptor := s.table.(*kvTable).acceptor
+	if string(name) == kshaka.DataNamespace {
+		return &bucket{db: s.db, table: newStateTable(s.db, acceptor)}
+	}
+	return &bucket{db: s.db, table: newKVTable(s.db, acceptor, string(name))}
+}
+
+// database is the database of a Store and its buckets.
+type database struct {
+	db      *sql.DB
+	dialect Dialect
+	// writes is held by the write, or transaction, in progress if dialect.SerializeWrites is true.
+	writes sync.Mutex
+}
+
+// begin starts a transaction.
+func (d *database) begin() (*sql.Tx, error) {
+	if d.dialect.SerializeWrites {
+		d.writes.Lock()
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.end()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// end is called once a transaction started by begin is committed or rolled back.
+func (d *database) end() {
+	if d.dialect.SerializeWrites {
+		d.writes.Unlock()
+	}
+}
+
+// rebind replaces the ? placeholders of query with those of the dialect.
+func (d *database) rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(d.dialect.Placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// table is the rows that hold the keys of a bucket.
+type table interface {
+	// get returns the value of key, or nil if there is no row for key. forUpdate locks the row.
+	get(q querier, key []byte, forUpdate bool) ([]byte, error)
+	set(q querier, key []byte, val []byte) error
+	delete(q querier, key []byte) error
+	// scan returns the keys that start with prefix, in order, and their values.
+	scan(q querier, prefix []byte) (keys [][]byte, vals [][]byte, err error)
+}
+
+// bucket is a StableStore of the rows of a table.
+type bucket struct {
+	db    *database
+	table table
+}
+
+// Set implements the kshaka.StableStore interface.
+func (b *bucket) Set(key []byte, val []byte) error {
+	return b.update(func(tx *sql.Tx) error {
+		return b.table.set(tx, key, val)
+	})
+}
+
+// Get implements the kshaka.StableStore interface.
+func (b *bucket) Get(key []byte) ([]byte, error) {
+	val, err := b.table.get(b.db.db, key, false)
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		return nil, errors.Wrap(kshaka.ErrNotFound, fmt.Sprintf("key:%v", key))
+	}
+	return val, nil
+}
+
+// SetUint64 implements the kshaka.StableStore interface.
+func (b *bucket) SetUint64(key []byte, val uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, val)
+	return b.Set(key, buf)
+}
+
+// GetUint64 implements the kshaka.StableStore interface.
+func (b *bucket) GetUint64(key []byte) (uint64, error) {
+	val, err := b.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("value of key:%v is %v bytes, not a uint64", key, len(val))
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// Delete implements the kshaka.DeleteStore interface.
+func (b *bucket) Delete(key []byte) error {
+	return b.update(func(tx *sql.Tx) error {
+		return b.table.delete(tx, key)
+	})
+}
+
+// IteratePrefix implements the kshaka.KeyIterator interface.
+// The keys are visited in order. They are all read before fn is called, so fn can write to the store.
+func (b *bucket) IteratePrefix(prefix []byte, fn func(key []byte, val []byte) error) error {
+	keys, vals, err := b.table.scan(b.db.db, prefix)
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		if err := fn(k, vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Begin implements the kshaka.TxnStore interface.
+func (b *bucket) Begin() (kshaka.Txn, error) {
+	tx, err := b.db.begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to begin a transaction")
+	}
+	return &txn{tx: tx, bucket: b}, nil
+}
+
+// update runs fn in a transaction of its own.
+func (b *bucket) update(fn func(tx *sql.Tx) error) error {
+	tx, err := b.db.begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to begin a transaction")
+	}
+	defer b.db.end()
+	if err := fn(tx); err != nil {
+		tx.Rollback() // nolint: errcheck
+		return err
+	}
+	return tx.Commit()
+}
+
+// txn is a database transaction.
+type txn struct {
+	tx     *sql.Tx
+	bucket *bucket
+	done   bool
+}
+
+func (t *txn) Get(key []byte) ([]byte, error) {
+	val, err := t.bucket.table.get(t.tx, key, true)
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		return nil, errors.Wrap(kshaka.ErrNotFound, fmt.Sprintf("key:%v", key))
+	}
+	return val, nil
+}
+
+func (t *txn) Set(key []byte, val []byte) error {
+	return t.bucket.table.set(t.tx, key, val)
+}
+
+func (t *txn) Delete(key []byte) error {
+	return t.bucket.table.delete(t.tx, key)
+}
+
+func (t *txn) Commit() error {
+	if t.done {
+		return errors.New("the transaction has already been committed or rolled back")
+	}
+	t.done = true
+	defer t.bucket.db.end()
+	return t.tx.Commit()
+}
+
+func (t *txn) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	defer t.bucket.db.end()
+	return t.tx.Rollback()
+}
+
+// kvTable keeps the keys of a bucket in the kshaka_kv table.
+type kvTable struct {
+	acceptor string
+	bucket   string
+	// the statements, with the placeholders of the dialect.
+	selectStmt, selectForUpdateStmt, upsertStmt, deleteStmt, scanStmt string
+	db                                                                *database
+}
+
+func newKVTable(d *database, acceptor string, bucket string) *kvTable {
+	selectStmt := "SELECT v FROM kshaka_kv WHERE acceptor = ? AND bucket = ? AND k = ?"
+	return &kvTable{
+		acceptor:            acceptor,
+		bucket:              bucket,
+		selectStmt:          d.rebind(selectStmt),
+		selectForUpdateStmt: d.rebind(selectStmt + d.dialect.ForUpdate),
+		upsertStmt:          d.rebind(d.dialect.Upsert("kshaka_kv", []string{"acceptor", "bucket", "k"}, []string{"acceptor", "bucket", "k", "v"})),
+		deleteStmt:          d.rebind("DELETE FROM kshaka_kv WHERE acceptor = ? AND bucket = ? AND k = ?"),
+		scanStmt:            "SELECT k, v FROM kshaka_kv WHERE acceptor = ? AND bucket = ?",
+		db:                  d,
+	}
+}
+
+func (t *kvTable) get(q querier, key []byte, forUpdate bool) ([]byte, error) {
+	stmt := t.selectStmt
+	if forUpdate {
+		stmt = t.selectForUpdateStmt
+	}
+	var val []byte
+	err := q.QueryRow(stmt, t.acceptor, t.bucket, key).Scan(&val)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to read key:%v", key))
+	}
+	if val == nil {
+		val = []byte{}
+	}
+	return val, nil
+}
+
+func (t *kvTable) set(q querier, key []byte, val []byte) error {
+	if _, err := q.Exec(t.upsertStmt, t.acceptor, t.bucket, key, val); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to write key:%v", key))
+	}
+	return nil
+}
+
+func (t *kvTable) delete(q querier, key []byte) error {
+	if _, err := q.Exec(t.deleteStmt, t.acceptor, t.bucket, key); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to delete key:%v", key))
+	}
+	return nil
+}
+
+func (t *kvTable) scan(q querier, prefix []byte) ([][]byte, [][]byte, error) {
+	stmt, args := prefixQuery(t.scanStmt, prefix, t.acceptor, t.bucket)
+	rows, err := q.Query(t.db.rebind(stmt), args...)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to read the keys")
+	}
+	defer rows.Close() // nolint: errcheck
+	keys, vals := [][]byte{}, [][]byte{}
+	for rows.Next() {
+		var k, val []byte
+		if err := rows.Scan(&k, &val); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read the keys")
+		}
+		keys, vals = append(keys, k), append(vals, val)
+	}
+	return keys, vals, rows.Err()
+}
+
+// stateTable keeps the acceptor states of the data namespace in the kshaka_acceptor_states table.
+// Its values are kshaka.AcceptorStates encoded with AcceptorState.MarshalBinary, which it stores as columns.
+type stateTable struct {
+	acceptor string
+	// the statements, with the placeholders of the dialect.
+	selectStmt, selectForUpdateStmt, upsertStmt, deleteStmt, scanStmt string
+	db                                                                *database
+}
+
+const stateColumns = "promised_counter, promised_node, promised_epoch, accepted_counter, accepted_node, accepted_epoch, value"
+
+func newStateTable(d *database, acceptor string) *stateTable {
+	selectStmt := "SELECT " + stateColumns + " FROM kshaka_acceptor_states WHERE acceptor = ? AND k = ?"
+	return &stateTable{
+		acceptor:            acceptor,
+		selectStmt:          d.rebind(selectStmt),
+		selectForUpdateStmt: d.rebind(selectStmt + d.dialect.ForUpdate),
+		upsertStmt:          d.rebind(d.dialect.Upsert("kshaka_acceptor_states", []string{"acceptor", "k"}, append([]string{"acceptor", "k"}, strings.Split(stateColumns, ", ")...))),
+		deleteStmt:          d.rebind("DELETE FROM kshaka_acceptor_states WHERE acceptor = ? AND k = ?"),
+		scanStmt:            "SELECT k, " + stateColumns + " FROM kshaka_acceptor_states WHERE acceptor = ?",
+		db:                  d,
+	}
+}
+
+func (t *stateTable) get(q querier, key []byte, forUpdate bool) ([]byte, error) {
+	stmt := t.selectStmt
+	if forUpdate {
+		stmt = t.selectForUpdateStmt
+	}
+	acceptorState, err := scanAcceptorState(q.QueryRow(stmt, t.acceptor, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to read the state of key:%v", key))
+	}
+	return acceptorState.MarshalBinary()
+}
+
+func (t *stateTable) set(q querier, key []byte, val []byte) error {
+	var a kshaka.AcceptorState
+	if err := a.UnmarshalBinary(val); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("the value of key:%v is not an acceptor state", key))
+	}
+	_, err := q.Exec(t.upsertStmt, t.acceptor, key,
+		int64(a.PromisedBallot.Counter), int64(a.PromisedBallot.NodeID), int64(a.PromisedBallot.Epoch),
+		int64(a.AcceptedBallot.Counter), int64(a.AcceptedBallot.NodeID), int64(a.AcceptedBallot.Epoch),
+		a.State)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to write the state of key:%v", key))
+	}
+	return nil
+}
+
+func (t *stateTable) delete(q querier, key []byte) error {
+	if _, err := q.Exec(t.deleteStmt, t.acceptor, key); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to delete the state of key:%v", key))
+	}
+	return nil
+}
+
+func (t *stateTable) scan(q querier, prefix []byte) ([][]byte, [][]byte, error) {
+	stmt, args := prefixQuery(t.scanStmt, prefix, t.acceptor)
+	rows, err := q.Query(t.db.rebind(stmt), args...)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to read the keys")
+	}
+	defer rows.Close() // nolint: errcheck
+	keys, vals := [][]byte{}, [][]byte{}
+	for rows.Next() {
+		var k []byte
+		acceptorState, err := scanAcceptorState(rows, &k)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read the keys")
+		}
+		val, err := acceptorState.MarshalBinary()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys, vals = append(keys, k), append(vals, val)
+	}
+	return keys, vals, rows.Err()
+}
+
+// scanAcceptorState scans the stateColumns of a row, after the columns that are scanned into dest.
+func scanAcceptorState(row interface{ Scan(...interface{}) error }, dest ...interface{}) (kshaka.AcceptorState, error) {
+	var a kshaka.AcceptorState
+	var ballots [6]int64
+	var state []byte
+	dest = append(dest, &ballots[0], &ballots[1], &ballots[2], &ballots[3], &ballots[4], &ballots[5], &state)
+	if err := row.Scan(dest...); err != nil {
+		return a, err
+	}
+	a.PromisedBallot = kshaka.Ballot{Counter: uint64(ballots[0]), NodeID: uint64(ballots[1]), Epoch: uint64(ballots[2])}
+	a.AcceptedBallot = kshaka.Ballot{Counter: uint64(ballots[3]), NodeID: uint64(ballots[4]), Epoch: uint64(ballots[5])}
+	if len(state) > 0 {
+		a.State = state
+	}
+	return a, nil
+}
+
+// prefixQuery adds the conditions that select the keys starting with prefix to stmt, and orders the rows by key.
+func prefixQuery(stmt string, prefix []byte, args ...interface{}) (string, []interface{}) {
+	if len(prefix) > 0 {
+		stmt += " AND k >= ?"
+		args = append(args, prefix)
+		if end := kshaka.PrefixEnd(prefix); end != nil {
+			stmt += " AND k < ?"
+			args = append(args, end)
+		}
+	}
+	return stmt + " ORDER BY k", args
+}
@@ -0,0 +1,50 @@
+package sqlStore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	query := "SELECT v FROM kshaka_kv WHERE acceptor = ? AND bucket = ? AND k = ?"
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{dialect: SQLite, want: query},
+		{dialect: Postgres, want: "SELECT v FROM kshaka_kv WHERE acceptor = $1 AND bucket = $2 AND k = $3"},
+	}
+	for _, tt := range tests {
+		d := &database{dialect: tt.dialect}
+		if got := d.rebind(query); got != tt.want {
+			t.Errorf("\nrebind() \ngot= %v, \nwant = %v", got, tt.want)
+		}
+	}
+}
+
+func TestOnConflictUpsert(t *testing.T) {
+	got := onConflictUpsert("kshaka_kv", []string{"acceptor", "bucket", "k"}, []string{"acceptor", "bucket", "k", "v"})
+	want := "INSERT INTO kshaka_kv (acceptor, bucket, k, v) VALUES (?, ?, ?, ?) ON CONFLICT (acceptor, bucket, k) DO UPDATE SET v = excluded.v"
+	if got != want {
+		t.Errorf("\nonConflictUpsert() \ngot= %v, \nwant = %v", got, want)
+	}
+}
+
+func TestPrefixQuery(t *testing.T) {
+	stmt := "SELECT k FROM kshaka_kv WHERE acceptor = ?"
+	tests := []struct {
+		prefix   []byte
+		wantStmt string
+		wantArgs []interface{}
+	}{
+		{prefix: nil, wantStmt: stmt + " ORDER BY k", wantArgs: []interface{}{"a"}},
+		{prefix: []byte("b/"), wantStmt: stmt + " AND k >= ? AND k < ? ORDER BY k", wantArgs: []interface{}{"a", []byte("b/"), []byte("b0")}},
+		{prefix: []byte{0xff}, wantStmt: stmt + " AND k >= ? ORDER BY k", wantArgs: []interface{}{"a", []byte{0xff}}},
+	}
+	for _, tt := range tests {
+		gotStmt, gotArgs := prefixQuery(stmt, tt.prefix, "a")
+		if gotStmt != tt.wantStmt || !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+			t.Errorf("\nprefixQuery(%q) \ngot= %v %q, \nwant = %v %q", tt.prefix, gotStmt, gotArgs, tt.wantStmt, tt.wantArgs)
+		}
+	}
+}
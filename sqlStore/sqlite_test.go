@@ -0,0 +1,236 @@
+//go:build sqlite
+// +build sqlite
+
+package sqlStore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/komuw/kshaka"
+	_ "modernc.org/sqlite"
+)
+
+// openDB opens an SQLite database in a new directory, and returns a func that removes it.
+func openDB(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "sqlStore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(dir, "kshaka.db")+"?_pragma=busy_timeout(5000)&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()        // nolint: errcheck
+		os.RemoveAll(dir) // nolint: errcheck
+	}
+}
+
+func openStore(t *testing.T, db *sql.DB, name string) *Store {
+	store, err := Open(db, SQLite, name)
+	if err != nil {
+		t.Fatalf("\nOpen() \nerr = %v", err)
+	}
+	return store
+}
+
+func TestStore(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	store := openStore(t, db, "acceptor-1")
+	key := []byte("foo")
+
+	if _, err := store.Get(key); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nstore.Get() \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+	if err := store.SetUint64(key, 7); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetUint64(key)
+	if err != nil || got != 7 {
+		t.Errorf("\nstore.GetUint64() \ngot= %v %v, \nwant = %v", got, err, 7)
+	}
+
+	// buckets, and other acceptors, are keyspaces of their own.
+	for _, other := range []kshaka.StableStore{store.Bucket([]byte("meta")), openStore(t, db, "acceptor-2")} {
+		if _, err := other.Get(key); !errors.Is(err, kshaka.ErrNotFound) {
+			t.Errorf("\nother.Get() \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+		}
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nstore.Get() \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+
+	for _, k := range []string{"a/2", "a/1", "b/1"} {
+		if err := store.Set([]byte(k), []byte("val")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := []string{}
+	err = store.IteratePrefix([]byte("a/"), func(k []byte, val []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/1", "a/2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("\nstore.IteratePrefix() \ngot= %v, \nwant = %v", keys, want)
+	}
+}
+
+func TestAcceptorStates(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	data := openStore(t, db, "acceptor-1").Bucket([]byte(kshaka.DataNamespace))
+	key := []byte("foo")
+	want := kshaka.AcceptorState{
+		PromisedBallot: kshaka.Ballot{Counter: 3, NodeID: 1 << 63, Epoch: 1},
+		AcceptedBallot: kshaka.Ballot{Counter: 2, NodeID: 2},
+		State:          []byte("bar"),
+	}
+	record, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := data.Set(key, record); err != nil {
+		t.Fatal(err)
+	}
+
+	// the state is stored as columns.
+	var counter, nodeID int64
+	var state []byte
+	err = db.QueryRow("SELECT promised_counter, promised_node, value FROM kshaka_acceptor_states WHERE acceptor = ? AND k = ?", "acceptor-1", key).Scan(&counter, &nodeID, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter != 3 || uint64(nodeID) != 1<<63 || string(state) != "bar" {
+		t.Errorf("\nrow \ngot= %v %v %s, \nwant = %v %v %s", counter, uint64(nodeID), state, 3, uint64(1<<63), "bar")
+	}
+
+	val, err := data.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got kshaka.AcceptorState
+	if err := got.UnmarshalBinary(val); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ndata.Get() \ngot= %#+v, \nwant = %#+v", got, want)
+	}
+
+	if err := data.Set(key, []byte("not an acceptor state")); err == nil {
+		t.Errorf("\ndata.Set() \nwanted an error for a value that is not an acceptor state")
+	}
+}
+
+func TestTxn(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	store := openStore(t, db, "acceptor-1")
+	key := []byte("foo")
+
+	txn, err := store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	val, err := txn.Get(key)
+	if err != nil || string(val) != "bar" {
+		t.Errorf("\ntxn.Get() \ngot= %s %v, \nwant = %s", val, err, "bar")
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\nstore.Get() after Rollback \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+
+	txn, err = store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Errorf("\ntxn.Rollback() after Commit \nerr = %v", err)
+	}
+	val, err = store.Get(key)
+	if err != nil || string(val) != "bar" {
+		t.Errorf("\nstore.Get() after Commit \ngot= %s %v, \nwant = %s", val, err, "bar")
+	}
+}
+
+// TestPropose runs a cluster whose acceptors all share one database.
+func TestPropose(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	nodes := []*kshaka.Node{}
+	for i := uint64(1); i <= 3; i++ {
+		n := kshaka.NewNode(i, openStore(t, db, fmt.Sprintf("acceptor-%v", i)))
+		n.AddTransport(&kshaka.InmemTransport{Node: n})
+		nodes = append(nodes, n)
+	}
+	kshaka.MingleNodes(nodes...)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 9; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := []byte(fmt.Sprintf("key-%v", i))
+			_, err := nodes[i%3].Propose(key, func(current []byte) ([]byte, error) { return key, nil })
+			if err != nil {
+				t.Errorf("\nnode.Propose() \nerr = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	newState, err := nodes[1].Read([]byte("key-1"))
+	if err != nil {
+		t.Fatalf("\nnode.Read() \nerr = %v", err)
+	}
+	if string(newState) != "key-1" {
+		t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", newState, "key-1")
+	}
+
+	if err := nodes[0].Delete([]byte("key-0")); err != nil {
+		t.Fatal(err)
+	}
+	collected, err := nodes[0].CollectGarbage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected != 1 {
+		t.Errorf("\nnode.CollectGarbage() \ngot= %v, \nwant = %v", collected, 1)
+	}
+	// only the rows of the deleted key are checked; the accept messages of the other keys may still be reaching the slowest acceptor.
+	var rows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM kshaka_acceptor_states WHERE k = ?", []byte("key-0")).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Errorf("\nrows of key-0 in kshaka_acceptor_states \ngot= %v, \nwant = %v", rows, 0)
+	}
+}
@@ -1,13 +1,78 @@
 package kshaka
 
+import (
+	"errors"
+)
+
+// ErrNotFound is returned by a StableStore for keys that it does not have.
+var ErrNotFound = errors.New("not found")
+
 // StableStore is used to provide stable storage
 // of key configurations to ensure safety.
 // This interface is the same as the one defined in hashicorp/raft
+// Implementations must be safe for concurrent use; a Node accesses its store concurrently for different keys.
+//
+// A write must be durable once it returns. A store that loses writes when the machine crashes, eg because it skips the fsync,
+// lets an acceptor forget the promises it made; which CASPaxos is not safe against.
+//
+// A key that is not in the store is reported with ErrNotFound, or an error that wraps it; any other error fails the operation that needed the key.
+// The stores of hashicorp/raft report missing keys differently, use RaftStore to adapt them.
 type StableStore interface {
 	Set(key []byte, val []byte) error
-	// Get returns the value for key, or an empty byte slice if key was not found.
+	// Get returns the value for key, or ErrNotFound if key was not found. An empty value is also taken to mean that key was not found.
 	Get(key []byte) ([]byte, error)
 	SetUint64(key []byte, val uint64) error
-	// GetUint64 returns the uint64 value for key, or 0 if key was not found.
+	// GetUint64 returns the uint64 value for key, or either 0 or ErrNotFound if key was not found.
 	GetUint64(key []byte) (uint64, error)
 }
+
+// KeyIterator is an optional interface that a StableStore can implement to let kshaka enumerate the keys that it holds.
+// Changing the membership of a cluster requires it, since every key has to be re-read through the new quorum.
+type KeyIterator interface {
+	// IteratePrefix calls fn with every key, and its value, in the store that starts with prefix.
+	// Iteration stops at the first error returned by fn, and that error is returned.
+	IteratePrefix(prefix []byte, fn func(key []byte, val []byte) error) error
+}
+
+// PrefixEnd returns the smallest key that is greater than every key that starts with prefix, or nil if there is none.
+// A store that keeps its keys sorted can implement KeyIterator by visiting the keys from prefix up to, but excluding, PrefixEnd(prefix).
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// DeleteStore is an optional interface that a StableStore can implement to let kshaka remove keys that it no longer needs.
+// Node.CollectGarbage uses it to remove the tombstones of deleted keys.
+type DeleteStore interface {
+	// Delete removes key from the store. Deleting a key that is not in the store is not an error.
+	Delete(key []byte) error
+}
+
+// TxnStore is an optional interface that a StableStore can implement to let kshaka read and write keys in transactions.
+// An acceptor then reads the state of a key, and writes its new state, in one transaction; so that the write is atomic
+// and no other process that shares the store can change the key in between.
+// Stores that do not implement TxnStore have each write applied on its own.
+type TxnStore interface {
+	// Begin starts a read-write transaction.
+	Begin() (Txn, error)
+}
+
+// Txn is a read-write transaction of a TxnStore.
+// Get sees the writes made earlier in the transaction, and none of the writes are visible to others until Commit.
+type Txn interface {
+	// Get returns the value for key, or ErrNotFound if key was not found.
+	Get(key []byte) ([]byte, error)
+	Set(key []byte, val []byte) error
+	// Delete removes key. Deleting a key that is not in the store is not an error.
+	Delete(key []byte) error
+	// Commit applies the writes of the transaction atomically.
+	Commit() error
+	// Rollback discards the writes of the transaction. Calling Rollback after Commit does nothing.
+	Rollback() error
+}
@@ -0,0 +1,23 @@
+package kshaka
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix []byte
+		want   []byte
+	}{
+		{prefix: nil, want: nil},
+		{prefix: []byte("a/"), want: []byte("a0")},
+		{prefix: []byte{'a', 0xff}, want: []byte("b")},
+		{prefix: []byte{0xff, 0xff}, want: nil},
+	}
+	for _, tt := range tests {
+		if got := PrefixEnd(tt.prefix); !bytes.Equal(got, tt.want) {
+			t.Errorf("\nPrefixEnd(%q) \ngot= %q, \nwant = %q", tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -1,9 +1,51 @@
 package kshaka
 
+import (
+	"context"
+)
+
 // Transport provides an interface for network transports
 // to allow kshaka/CASPaxos to communicate with other nodes.
 // An example is github.com/komuw/kshaka/httpTransport
+// Implementations should abort the call and return ctx.Err() once ctx is done.
+//
+// An acceptor that rejects a message replies with an *ErrConflict, which carries the acceptor's state;
+// a Transport should return that error, or one that wraps it, so that the proposer can tell it apart from a failure to deliver the message.
+// Any other error is taken to be a delivery failure and the AcceptorState returned with it is ignored.
+// MarshalError and UnmarshalError can be used to send an *ErrConflict over the network.
 type Transport interface {
-	TransportPrepare(b Ballot, key []byte) (AcceptorState, error)
-	TransportAccept(b Ballot, key []byte, state []byte) (AcceptorState, error)
+	TransportPrepare(ctx context.Context, b Ballot, key []byte) (AcceptorState, error)
+	TransportAccept(ctx context.Context, b Ballot, key []byte, state []byte) (AcceptorState, error)
+}
+
+// AcceptPrepareTransport is an optional interface that a Transport can implement to support one round trip writes.
+// TransportAcceptPrepare sends an accept message for Ballot b that also carries the prepare message for the next Ballot.
+// CanAcceptPrepare reports whether the acceptor at the other end can be sent those messages;
+// a Node only uses TransportAcceptPrepare if it returns true. See Node.EnableOneRoundTrip
+type AcceptPrepareTransport interface {
+	Transport
+	TransportAcceptPrepare(ctx context.Context, b Ballot, key []byte, state []byte, next Ballot) (AcceptorState, error)
+	CanAcceptPrepare() bool
+}
+
+// ConfigTransport is an optional interface that a Transport can implement to let membership changes reach nodes in other processes.
+// TransportConfig sends the configuration c to the node, and returns the epoch of the configuration that the node runs afterwards;
+// which is newer than c.Epoch if the node ignored c. TransportKeys asks the acceptor for the keys that it holds state for.
+// TransportAge sends the age bump of a garbage collection, and returns the epoch that the node runs afterwards like TransportConfig.
+// The node at the other end handles them with Node.ApplyConfig, Node.Keys and Node.BumpAge; see Node.AddAcceptor
+type ConfigTransport interface {
+	Transport
+	TransportConfig(ctx context.Context, c Config) (uint64, error)
+	TransportKeys(ctx context.Context) ([][]byte, error)
+	TransportAge(ctx context.Context, a Age) (uint64, error)
+}
+
+// GCTransport is an optional interface that a Transport can implement to let garbage collection reach nodes in other processes.
+// TransportTombstones asks the acceptor for the keys for which it holds a tombstone. TransportRemoveTombstone asks it to remove
+// the tombstone of key that was accepted with Ballot b, and reports whether the key no longer has any state.
+// The node at the other end handles them with Node.Tombstones and Node.RemoveTombstone; see Node.CollectGarbage
+type GCTransport interface {
+	ConfigTransport
+	TransportTombstones(ctx context.Context) ([][]byte, error)
+	TransportRemoveTombstone(ctx context.Context, b Ballot, key []byte) (bool, error)
 }
@@ -0,0 +1,56 @@
+package kshaka
+
+import (
+	"github.com/pkg/errors"
+)
+
+// storeTxn is the Txn of a store that does not implement TxnStore; its writes are applied to the store as they are made.
+type storeTxn struct {
+	store StableStore
+}
+
+func (t *storeTxn) Get(key []byte) ([]byte, error)   { return t.store.Get(key) }
+func (t *storeTxn) Set(key []byte, val []byte) error { return t.store.Set(key, val) }
+func (t *storeTxn) Delete(key []byte) error          { return removeKey(t.store, key) }
+func (t *storeTxn) Commit() error                    { return nil }
+func (t *storeTxn) Rollback() error                  { return nil }
+
+// namespacedTxnStore is the TxnStore of a namespace of a store that does not implement BucketStore.
+type namespacedTxnStore struct {
+	store TxnStore
+	ns    *NamespacedStore
+}
+
+func (s *namespacedTxnStore) Begin() (Txn, error) {
+	txn, err := s.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &namespacedTxn{txn: txn, ns: s.ns}, nil
+}
+
+// namespacedTxn is a Txn that keeps its keys under the prefix of a NamespacedStore.
+type namespacedTxn struct {
+	txn Txn
+	ns  *NamespacedStore
+}
+
+func (t *namespacedTxn) Get(key []byte) ([]byte, error)   { return t.txn.Get(t.ns.key(key)) }
+func (t *namespacedTxn) Set(key []byte, val []byte) error { return t.txn.Set(t.ns.key(key), val) }
+func (t *namespacedTxn) Delete(key []byte) error          { return t.txn.Delete(t.ns.key(key)) }
+func (t *namespacedTxn) Commit() error                    { return t.txn.Commit() }
+func (t *namespacedTxn) Rollback() error                  { return t.txn.Rollback() }
+
+// beginData starts a transaction on the data namespace of the node's store.
+// If the store does not implement TxnStore, the writes of the transaction are applied as they are made.
+func (n *Node) beginData() (Txn, error) {
+	data := n.dataStore()
+	if n.dataTxns == nil {
+		return &storeTxn{store: data}, nil
+	}
+	txn, err := n.dataTxns.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to begin a transaction")
+	}
+	return txn, nil
+}
@@ -0,0 +1,199 @@
+package kshaka
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInmemTxn(t *testing.T) {
+	store := &InmemStore{}
+	if err := store.Set([]byte("old"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn, err := store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete([]byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	val, err := txn.Get([]byte("foo"))
+	if err != nil || string(val) != "bar" {
+		t.Errorf("\ntxn.Get() \ngot= %s %v, \nwant = %s", val, err, "bar")
+	}
+	if _, err := txn.Get([]byte("old")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("\ntxn.Get() of a deleted key \ngot= %v, \nwant = %v", err, ErrNotFound)
+	}
+	if _, err := store.Get([]byte("foo")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("\nstore.Get() before Commit \ngot= %v, \nwant = %v", err, ErrNotFound)
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Errorf("\ntxn.Rollback() after Commit \nerr = %v", err)
+	}
+	if err := txn.Commit(); err == nil {
+		t.Errorf("\ntxn.Commit() \nwanted an error for a second Commit")
+	}
+	val, err = store.Get([]byte("foo"))
+	if err != nil || string(val) != "bar" {
+		t.Errorf("\nstore.Get() after Commit \ngot= %s %v, \nwant = %s", val, err, "bar")
+	}
+	if _, err := store.Get([]byte("old")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("\nstore.Get() of a deleted key \ngot= %v, \nwant = %v", err, ErrNotFound)
+	}
+
+	txn, err = store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set([]byte("foo"), []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	val, err = store.Get([]byte("foo"))
+	if err != nil || string(val) != "bar" {
+		t.Errorf("\nstore.Get() after Rollback \ngot= %s %v, \nwant = %s", val, err, "bar")
+	}
+}
+
+func TestInmemTxnKeyLocks(t *testing.T) {
+	store := &InmemStore{}
+	txn, err := store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set([]byte("foo"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a transaction on another key is not blocked.
+	done := make(chan error)
+	go func() {
+		other, err := store.Begin()
+		if err != nil {
+			done <- err
+			return
+		}
+		if err := other.Set([]byte("bar"), []byte("1")); err != nil {
+			done <- err
+			return
+		}
+		done <- other.Commit()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("\ntxn.Commit() \na transaction on key bar was blocked by one on key foo")
+	}
+
+	// a transaction on the same key waits for the first one to finish.
+	go func() {
+		same, err := store.Begin()
+		if err != nil {
+			done <- err
+			return
+		}
+		if _, err := same.Get([]byte("foo")); err != nil {
+			done <- err
+			return
+		}
+		done <- same.Rollback()
+	}()
+	select {
+	case <-done:
+		t.Fatalf("\ntxn.Get() \na transaction on key foo was not blocked by another one on key foo")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("\ntxn.Get() after the other transaction committed \nerr = %v", err)
+	}
+}
+
+// txnCountingStore is an InmemStore that counts its transactions, and fails their Commit if failCommit is set.
+type txnCountingStore struct {
+	InmemStore
+	commits    int
+	failCommit bool
+}
+
+func (s *txnCountingStore) Begin() (Txn, error) {
+	txn, err := s.InmemStore.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &countingTxn{Txn: txn, store: s}, nil
+}
+
+type countingTxn struct {
+	Txn
+	store *txnCountingStore
+}
+
+func (t *countingTxn) Commit() error {
+	if t.store.failCommit {
+		return errors.New("disk full")
+	}
+	t.store.commits++
+	return t.Txn.Commit()
+}
+
+func TestAcceptTxn(t *testing.T) {
+	store := &txnCountingStore{}
+	n := NewNode(1, store)
+	key := []byte("foo")
+
+	if _, err := n.Prepare(Ballot{Counter: 1, NodeID: 2}, key); err != nil {
+		t.Fatal(err)
+	}
+	want, err := n.Accept(Ballot{Counter: 1, NodeID: 2}, key, []byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.commits != 2 {
+		t.Errorf("\ncommitted transactions \ngot= %v, \nwant = %v", store.commits, 2)
+	}
+
+	// a conflict is not written, and its transaction is rolled back.
+	if _, err := n.Accept(Ballot{Counter: 1, NodeID: 1}, key, []byte("baz")); err == nil {
+		t.Errorf("\nn.Accept() \nwanted a conflict")
+	}
+	if store.commits != 2 {
+		t.Errorf("\ncommitted transactions \ngot= %v, \nwant = %v", store.commits, 2)
+	}
+
+	// the promise, accepted Ballot and state are all left as they were if the transaction fails.
+	store.failCommit = true
+	if _, err := n.Accept(Ballot{Counter: 2, NodeID: 2}, key, []byte("baz")); err == nil {
+		t.Errorf("\nn.Accept() \nwanted the error of the failed commit")
+	}
+	store.failCommit = false
+	got, err := n.getAcceptorState(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\nn.getAcceptorState() \ngot = %#+v, \nwanted = %#+v", got, want)
+	}
+
+	// the failed transaction was rolled back, so the store can be written to again.
+	if _, err := n.Accept(Ballot{Counter: 3, NodeID: 2}, key, []byte("baz")); err != nil {
+		t.Errorf("\nn.Accept() \nerr = %v", err)
+	}
+}
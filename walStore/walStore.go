@@ -0,0 +1,644 @@
+/*
+Package walStore provides a durable kshaka StableStore that is kept in a directory on local disk.
+
+Every write is appended to a write-ahead log(the file called wal) as a record that is checked with a CRC,
+and applied to a copy of the keys that the store keeps in memory. Once the log grows past Options.CompactAfter bytes,
+the keys are written to a snapshot(the file called snapshot) and the log is emptied.
+Opening a store replays the snapshot and then the log. A record that was cut short by a crash can only be the last one in the log,
+and is removed. A record that is corrupt while valid records follow it makes Open fail, rather than drop those records.
+
+A record is laid out as:
+
+	crc(4 bytes) | length(4) | ops(length bytes)
+
+crc is the CRC-32C(Castagnoli) of the length and ops, and integers are big-endian. Each op is either
+
+	opSet(1 byte) | key length(uvarint) | key | value length(uvarint) | value
+	opDelete(1 byte) | key length(uvarint) | key
+
+The ops of a record are applied together or not at all; the store implements kshaka.TxnStore by writing every transaction as one record.
+It also implements kshaka.KeyIterator and kshaka.DeleteStore.
+
+A directory should only be opened by one Store at a time.
+*/
+package walStore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/komuw/kshaka"
+	"github.com/pkg/errors"
+)
+
+const (
+	walFile      = "wal"
+	snapshotFile = "snapshot"
+
+	recordHeaderLen = 8 // crc and length.
+
+	opSet    byte = 1
+	opDelete byte = 2
+
+	// txnLockStripes is the number of locks that the keys touched by transactions are spread across.
+	txnLockStripes = 1024
+)
+
+// DefaultCompactAfter is the size of the log, in bytes, after which it is compacted if Options.CompactAfter is zero.
+const DefaultCompactAfter = 4 << 20
+
+// DefaultSyncInterval is how often the log is synced in SyncInterval mode if Options.SyncInterval is zero.
+const DefaultSyncInterval = 100 * time.Millisecond
+
+// ErrClosed is returned by the methods of a Store that has been closed.
+var ErrClosed = errors.New("walStore: the store is closed")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// SyncMode controls when the log is synced(fsync) to disk.
+type SyncMode int
+
+const (
+	// SyncAlways syncs the log before every write returns; a write that returned survives a crash of the machine.
+	// It is the only mode in which an acceptor keeps every promise it made, which the safety of CASPaxos relies on.
+	SyncAlways SyncMode = iota
+	// SyncInterval syncs the log every Options.SyncInterval.
+	// The writes of the last interval are lost if the machine crashes, but not if only the process does.
+	SyncInterval
+	// SyncNever leaves it to the operating system to write the log to disk.
+	SyncNever
+)
+
+// Options configures a Store.
+type Options struct {
+	Sync SyncMode
+	// SyncInterval is how often the log is synced in SyncInterval mode. It defaults to DefaultSyncInterval.
+	SyncInterval time.Duration
+	// CompactAfter is the size of the log, in bytes, after which it is compacted into the snapshot.
+	// It defaults to DefaultCompactAfter; a negative value turns compaction off, except through Store.Compact.
+	CompactAfter int64
+}
+
+// Store is a StableStore that is kept in a write-ahead log and a snapshot.
+// Create one with Open.
+type Store struct {
+	dir  string
+	opts Options
+
+	l       sync.RWMutex
+	kv      map[string][]byte
+	wal     *os.File
+	walSize int64
+	// dirty is true if the log has been written to since it was last synced.
+	dirty bool
+	// err is set once syncing the log fails; the store no longer knows what is on disk, so every later write fails with it.
+	err    error
+	closed bool
+
+	// txnLocks are held by the transactions in progress, for the keys that they have touched; see txnLock.
+	txnLocks [txnLockStripes]sync.Mutex
+
+	stopSync chan struct{}
+	syncDone chan struct{}
+}
+
+// Open opens the store kept in dir, creating dir if it does not exist, and recovers the keys written to it before.
+//
+//	store, err := walStore.Open("/var/lib/kshaka", walStore.Options{Sync: walStore.SyncAlways})
+//	node := kshaka.NewNode(1, store)
+func Open(dir string, opts Options) (*Store, error) {
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if opts.CompactAfter == 0 {
+		opts.CompactAfter = DefaultCompactAfter
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to create directory:%v", dir))
+	}
+
+	s := &Store{dir: dir, opts: opts, kv: map[string][]byte{}}
+	err = s.recover()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Sync == SyncInterval {
+		s.stopSync = make(chan struct{})
+		s.syncDone = make(chan struct{})
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// recover loads the snapshot, replays the log on top of it and cuts off the torn record at the end of the log, if any.
+// It fails if a record in the middle of the log is corrupt.
+func (s *Store) recover() error {
+	// a snapshot that was being written when the process stopped.
+	err := os.Remove(filepath.Join(s.dir, snapshotFile+".tmp"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	snapshot, err := ioutil.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "unable to read the snapshot")
+	}
+	// the snapshot is renamed into place once it has been synced, so it is never torn.
+	if n := s.replay(snapshot); n != len(snapshot) {
+		return fmt.Errorf("the snapshot is corrupt at offset:%v", n)
+	}
+
+	s.wal, err = os.OpenFile(filepath.Join(s.dir, walFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "unable to open the log")
+	}
+	log, err := ioutil.ReadAll(s.wal)
+	if err != nil {
+		s.wal.Close() // nolint: errcheck
+		return errors.Wrap(err, "unable to read the log")
+	}
+	n := s.replay(log)
+	if n != len(log) {
+		if followedByRecord(log[n:]) {
+			s.wal.Close() // nolint: errcheck
+			return fmt.Errorf("the log is corrupt at offset:%v, and valid records follow it", n)
+		}
+		err = s.wal.Truncate(int64(n))
+		if err == nil {
+			err = s.wal.Sync()
+		}
+		if err != nil {
+			s.wal.Close() // nolint: errcheck
+			return errors.Wrap(err, fmt.Sprintf("unable to remove the torn record at offset:%v of the log", n))
+		}
+	}
+	s.walSize = int64(n)
+	return nil
+}
+
+// replay applies the records in data, up to the first one that is torn or corrupt, and returns the number of bytes that it applied.
+func (s *Store) replay(data []byte) int {
+	n := 0
+	for n < len(data) {
+		ops, size, ok := readRecord(data[n:])
+		if !ok {
+			break
+		}
+		s.apply(ops)
+		n += size
+	}
+	return n
+}
+
+// followedByRecord reports whether a valid record starts anywhere in data after the record at its start, which could not be read.
+// A write that was cut short by a crash is the last one in the log, so it is not followed by any.
+func followedByRecord(data []byte) bool {
+	for i := 1; i+recordHeaderLen <= len(data); i++ {
+		if _, _, ok := readRecord(data[i:]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Set implements the kshaka.StableStore interface.
+func (s *Store) Set(key []byte, val []byte) error {
+	return s.write([]op{{key: key, val: val}})
+}
+
+// Get implements the kshaka.StableStore interface.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	val, ok := s.kv[string(key)]
+	if !ok {
+		return nil, errors.Wrap(kshaka.ErrNotFound, fmt.Sprintf("key:%v", key))
+	}
+	return append([]byte{}, val...), nil
+}
+
+// SetUint64 implements the kshaka.StableStore interface.
+func (s *Store) SetUint64(key []byte, val uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, val)
+	return s.Set(key, buf)
+}
+
+// GetUint64 implements the kshaka.StableStore interface.
+func (s *Store) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("value of key:%v is %v bytes, not a uint64", key, len(val))
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// Delete implements the kshaka.DeleteStore interface.
+func (s *Store) Delete(key []byte) error {
+	return s.write([]op{{key: key, delete: true}})
+}
+
+// IteratePrefix implements the kshaka.KeyIterator interface.
+// The keys are visited in order. fn is called without holding any lock, so it can write to the store.
+func (s *Store) IteratePrefix(prefix []byte, fn func(key []byte, val []byte) error) error {
+	s.l.RLock()
+	if s.closed {
+		s.l.RUnlock()
+		return ErrClosed
+	}
+	keys := []string{}
+	vals := map[string][]byte{}
+	for k, val := range s.kv {
+		if bytes.HasPrefix([]byte(k), prefix) {
+			keys = append(keys, k)
+			vals[k] = append([]byte{}, val...)
+		}
+	}
+	s.l.RUnlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn([]byte(k), vals[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync syncs the log to disk. It is only needed in the SyncInterval and SyncNever modes.
+func (s *Store) Sync() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.sync()
+}
+
+// Compact writes the keys to the snapshot and empties the log.
+// Writes are blocked while it runs.
+func (s *Store) Compact() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.compact()
+}
+
+// Close syncs the log to disk and closes the store.
+func (s *Store) Close() error {
+	s.l.Lock()
+	if s.closed {
+		s.l.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.sync()
+	if cerr := s.wal.Close(); err == nil {
+		err = cerr
+	}
+	s.l.Unlock()
+
+	if s.stopSync != nil {
+		close(s.stopSync)
+		<-s.syncDone
+	}
+	return err
+}
+
+// write appends ops to the log as one record and applies them.
+func (s *Store) write(ops []op) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	record := appendRecord(nil, ops)
+	_, err := s.wal.Write(record)
+	if err != nil {
+		// a partial write leaves a torn record in the log; the records written after it would be dropped on recovery.
+		if terr := s.wal.Truncate(s.walSize); terr != nil {
+			s.err = errors.Wrap(terr, "unable to remove a torn record from the log")
+		}
+		return errors.Wrap(err, "unable to write to the log")
+	}
+	s.walSize += int64(len(record))
+	s.dirty = true
+	if s.opts.Sync == SyncAlways {
+		if err := s.sync(); err != nil {
+			return err
+		}
+	}
+	s.apply(ops)
+
+	if s.opts.CompactAfter > 0 && s.walSize >= s.opts.CompactAfter {
+		if err := s.compact(); err != nil {
+			return errors.Wrap(err, "the write was applied, but the log could not be compacted")
+		}
+	}
+	return nil
+}
+
+// sync syncs the log, if it has been written to since it was last synced. s.l has to be held.
+func (s *Store) sync() error {
+	if s.err != nil {
+		return s.err
+	}
+	if !s.dirty {
+		return nil
+	}
+	if err := s.wal.Sync(); err != nil {
+		// once fsync fails the kernel may have dropped the writes that it had not flushed; retrying it would wrongly succeed.
+		s.err = errors.Wrap(err, "unable to sync the log")
+		return s.err
+	}
+	s.dirty = false
+	return nil
+}
+
+func (s *Store) syncLoop() {
+	defer close(s.syncDone)
+	ticker := time.NewTicker(s.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.l.Lock()
+			if !s.closed {
+				s.sync() // nolint: errcheck
+			}
+			s.l.Unlock()
+		case <-s.stopSync:
+			return
+		}
+	}
+}
+
+// compact writes the keys to a new snapshot, renames it over the old one and then empties the log. s.l has to be held.
+// If the process stops before the log is emptied, the log is replayed over the new snapshot on recovery; which leaves the same keys.
+func (s *Store) compact() error {
+	if s.err != nil {
+		return s.err
+	}
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "unable to create the snapshot")
+	}
+	w := bufio.NewWriter(f)
+	var record []byte
+	for k, val := range s.kv {
+		record = appendRecord(record[:0], []op{{key: []byte(k), val: val}})
+		if _, err = w.Write(record); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return errors.Wrap(err, "unable to write the snapshot")
+	}
+
+	err = os.Rename(tmp, filepath.Join(s.dir, snapshotFile))
+	if err != nil {
+		return errors.Wrap(err, "unable to rename the snapshot")
+	}
+	err = syncDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	err = s.wal.Truncate(0)
+	if err != nil {
+		return errors.Wrap(err, "unable to empty the log")
+	}
+	s.walSize = 0
+	s.dirty = true
+	return s.sync()
+}
+
+// syncDir syncs dir, so that the files renamed into it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to sync directory:%v", dir))
+	}
+	return nil
+}
+
+// apply applies ops to the keys kept in memory. s.l has to be held, except during recovery.
+func (s *Store) apply(ops []op) {
+	for _, o := range ops {
+		if o.delete {
+			delete(s.kv, string(o.key))
+		} else {
+			s.kv[string(o.key)] = append([]byte{}, o.val...)
+		}
+	}
+}
+
+// Begin implements the kshaka.TxnStore interface.
+// A transaction locks each key that it touches until it is committed or rolled back, so transactions on different keys run in parallel.
+// Transactions that touch the same keys in a different order can deadlock; a node touches one key per transaction.
+func (s *Store) Begin() (kshaka.Txn, error) {
+	return &txn{store: s, writes: map[string]int{}, locked: map[*sync.Mutex]bool{}}, nil
+}
+
+// txnLock returns the lock that transactions take for key.
+func (s *Store) txnLock(key []byte) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write(key) // hash.Hash never returns an error.
+	return &s.txnLocks[h.Sum32()%txnLockStripes]
+}
+
+// txn buffers its writes until Commit, which writes them to the log as one record.
+type txn struct {
+	store *Store
+	ops   []op
+	// writes holds the index in ops of the last write of each key.
+	writes map[string]int
+	locked map[*sync.Mutex]bool
+	done   bool
+}
+
+// lock locks key for the rest of the transaction; keys that share a lock only take it once.
+func (t *txn) lock(key []byte) {
+	mu := t.store.txnLock(key)
+	if !t.locked[mu] {
+		mu.Lock()
+		t.locked[mu] = true
+	}
+}
+
+// unlock releases the keys that the transaction has locked.
+func (t *txn) unlock() {
+	for mu := range t.locked {
+		mu.Unlock()
+	}
+	t.locked = nil
+}
+
+func (t *txn) Get(key []byte) ([]byte, error) {
+	t.lock(key)
+	if i, ok := t.writes[string(key)]; ok {
+		if t.ops[i].delete {
+			return nil, errors.Wrap(kshaka.ErrNotFound, fmt.Sprintf("key:%v", key))
+		}
+		return append([]byte{}, t.ops[i].val...), nil
+	}
+	return t.store.Get(key)
+}
+
+func (t *txn) Set(key []byte, val []byte) error {
+	t.lock(key)
+	t.writes[string(key)] = len(t.ops)
+	t.ops = append(t.ops, op{key: append([]byte{}, key...), val: append([]byte{}, val...)})
+	return nil
+}
+
+func (t *txn) Delete(key []byte) error {
+	t.lock(key)
+	t.writes[string(key)] = len(t.ops)
+	t.ops = append(t.ops, op{key: append([]byte{}, key...), delete: true})
+	return nil
+}
+
+func (t *txn) Commit() error {
+	if t.done {
+		return errors.New("the transaction has already been committed or rolled back")
+	}
+	t.done = true
+	defer t.unlock()
+	if len(t.ops) == 0 {
+		return nil
+	}
+	return t.store.write(t.ops)
+}
+
+func (t *txn) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	t.unlock()
+	return nil
+}
+
+// op is a write to a key.
+type op struct {
+	key    []byte
+	val    []byte
+	delete bool
+}
+
+// appendRecord appends the record of ops to buf.
+func appendRecord(buf []byte, ops []op) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, recordHeaderLen)...)
+	for _, o := range ops {
+		if o.delete {
+			buf = append(buf, opDelete)
+			buf = appendBytes(buf, o.key)
+			continue
+		}
+		buf = append(buf, opSet)
+		buf = appendBytes(buf, o.key)
+		buf = appendBytes(buf, o.val)
+	}
+	record := buf[start:]
+	binary.BigEndian.PutUint32(record[4:], uint32(len(record)-recordHeaderLen))
+	binary.BigEndian.PutUint32(record, crc32.Checksum(record[4:], castagnoli))
+	return buf
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(b)))]...)
+	return append(buf, b...)
+}
+
+// readRecord decodes the record at the start of data, and returns its ops and size.
+// ok is false if the record is torn or corrupt.
+func readRecord(data []byte) (ops []op, size int, ok bool) {
+	if len(data) < recordHeaderLen {
+		return nil, 0, false
+	}
+	length := binary.BigEndian.Uint32(data[4:])
+	if uint64(length) > uint64(len(data)-recordHeaderLen) {
+		return nil, 0, false
+	}
+	size = recordHeaderLen + int(length)
+	if crc32.Checksum(data[4:size], castagnoli) != binary.BigEndian.Uint32(data) {
+		return nil, 0, false
+	}
+
+	payload := data[recordHeaderLen:size]
+	for len(payload) > 0 {
+		var o op
+		kind := payload[0]
+		payload = payload[1:]
+		o.key, payload, ok = readBytes(payload)
+		if !ok {
+			return nil, 0, false
+		}
+		switch kind {
+		case opSet:
+			o.val, payload, ok = readBytes(payload)
+			if !ok {
+				return nil, 0, false
+			}
+		case opDelete:
+			o.delete = true
+		default:
+			return nil, 0, false
+		}
+		ops = append(ops, o)
+	}
+	return ops, size, true
+}
+
+func readBytes(data []byte) ([]byte, []byte, bool) {
+	n, l := binary.Uvarint(data)
+	if l <= 0 || n > uint64(len(data)-l) {
+		return nil, nil, false
+	}
+	end := l + int(n)
+	return data[l:end], data[end:], true
+}
@@ -0,0 +1,464 @@
+package walStore
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/komuw/kshaka"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "walStore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) } // nolint: errcheck
+}
+
+func openStore(t *testing.T, dir string, opts Options) *Store {
+	s, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("\nOpen() \nerr = %v", err)
+	}
+	return s
+}
+
+// dump returns all the keys in s, and their values.
+func dump(t *testing.T, s *Store) map[string]string {
+	kv := map[string]string{}
+	err := s.IteratePrefix(nil, func(k []byte, val []byte) error {
+		kv[string(k)] = string(val)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kv
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := map[string]string{}
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func TestStore(t *testing.T) {
+	for _, mode := range []SyncMode{SyncAlways, SyncInterval, SyncNever} {
+		t.Run(fmt.Sprintf("sync mode %v", mode), func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+			opts := Options{Sync: mode, SyncInterval: time.Millisecond}
+			s := openStore(t, dir, opts)
+
+			if _, err := s.Get([]byte("foo")); !errors.Is(err, kshaka.ErrNotFound) {
+				t.Errorf("\ns.Get() \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+			}
+			if err := s.Set([]byte("foo"), []byte("bar")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetUint64([]byte("counter"), 7); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Set([]byte("gone"), []byte("soon")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete([]byte("gone")); err != nil {
+				t.Fatal(err)
+			}
+			if mode == SyncInterval {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Get([]byte("foo")); !errors.Is(err, ErrClosed) {
+				t.Errorf("\ns.Get() after Close \ngot= %v, \nwant = %v", err, ErrClosed)
+			}
+
+			s = openStore(t, dir, opts)
+			defer s.Close() // nolint: errcheck
+			want := map[string]string{"foo": "bar", "counter": "\x00\x00\x00\x00\x00\x00\x00\x07"}
+			if got := dump(t, s); !reflect.DeepEqual(got, want) {
+				t.Errorf("\nreopened store \ngot= %q, \nwant = %q", got, want)
+			}
+			counter, err := s.GetUint64([]byte("counter"))
+			if err != nil || counter != 7 {
+				t.Errorf("\ns.GetUint64() \ngot= %v %v, \nwant = %v", counter, err, 7)
+			}
+		})
+	}
+}
+
+func TestTxn(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := openStore(t, dir, Options{})
+	defer s.Close() // nolint: errcheck
+
+	txn, err := s.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set([]byte("baz"), []byte("qux")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete([]byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.Get([]byte("baz")); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\ntxn.Get() of a deleted key \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+	if _, err := s.Get([]byte("foo")); !errors.Is(err, kshaka.ErrNotFound) {
+		t.Errorf("\ns.Get() before Commit \ngot= %v, \nwant = %v", err, kshaka.ErrNotFound)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Errorf("\ntxn.Rollback() after Commit \nerr = %v", err)
+	}
+	if got, want := dump(t, s), map[string]string{"foo": "bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("\nafter Commit \ngot= %q, \nwant = %q", got, want)
+	}
+
+	txn, err = s.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set([]byte("foo"), []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dump(t, s), map[string]string{"foo": "bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("\nafter Rollback \ngot= %q, \nwant = %q", got, want)
+	}
+}
+
+func TestTxnKeyLocks(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := openStore(t, dir, Options{})
+	defer s.Close() // nolint: errcheck
+
+	txn, err := s.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set([]byte("foo"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a transaction on another key is not blocked.
+	done := make(chan error)
+	go func() {
+		other, err := s.Begin()
+		if err != nil {
+			done <- err
+			return
+		}
+		if err := other.Set([]byte("bar"), []byte("1")); err != nil {
+			done <- err
+			return
+		}
+		done <- other.Commit()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("\ntxn.Commit() \na transaction on key bar was blocked by one on key foo")
+	}
+
+	// a transaction on the same key waits for the first one to finish.
+	go func() {
+		same, err := s.Begin()
+		if err != nil {
+			done <- err
+			return
+		}
+		if _, err := same.Get([]byte("foo")); err != nil {
+			done <- err
+			return
+		}
+		done <- same.Rollback()
+	}()
+	select {
+	case <-done:
+		t.Fatalf("\ntxn.Get() \na transaction on key foo was not blocked by another one on key foo")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("\ntxn.Get() after the other transaction committed \nerr = %v", err)
+	}
+}
+
+// TestRecovery cuts the log off at every byte offset, like a crash in the middle of a write would,
+// and checks that the store recovers all the records that were completely written and nothing else.
+func TestRecovery(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := openStore(t, dir, Options{CompactAfter: -1})
+
+	writes := []func() error{
+		func() error { return s.Set([]byte("foo"), []byte("bar")) },
+		func() error { return s.SetUint64([]byte("counter"), 1) },
+		func() error { return s.Set([]byte("foo"), []byte("baz")) },
+		func() error { return s.Set([]byte("empty"), []byte{}) },
+		func() error { return s.Delete([]byte("foo")) },
+		func() error {
+			txn, err := s.Begin()
+			if err != nil {
+				return err
+			}
+			defer txn.Rollback() // nolint: errcheck
+			for _, k := range []string{"a", "b"} {
+				if err := txn.Set([]byte(k), []byte("val-"+k)); err != nil {
+					return err
+				}
+			}
+			if err := txn.Delete([]byte("empty")); err != nil {
+				return err
+			}
+			return txn.Commit()
+		},
+	}
+	// offsets[i] is the size of the log after i writes, and states[i] the keys of the store at that point.
+	offsets := []int{0}
+	states := []map[string]string{{}}
+	for _, write := range writes {
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filepath.Join(dir, walFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, int(info.Size()))
+		states = append(states, dump(t, s))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	log, err := ioutil.ReadFile(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for cut := 0; cut <= len(log); cut++ {
+		complete := 0
+		for i, offset := range offsets {
+			if offset <= cut {
+				complete = i
+			}
+		}
+		want := states[complete]
+
+		crashed, cleanup := tempDir(t)
+		if err := ioutil.WriteFile(filepath.Join(crashed, walFile), log[:cut], 0600); err != nil {
+			t.Fatal(err)
+		}
+		s := openStore(t, crashed, Options{})
+		if got := dump(t, s); !reflect.DeepEqual(got, want) {
+			t.Errorf("\nlog cut at offset:%v \ngot= %q, \nwant = %q", cut, got, want)
+		}
+
+		// the torn record is gone, so the records written after recovery are not lost behind it.
+		if err := s.Set([]byte("after"), []byte("crash")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		s = openStore(t, crashed, Options{})
+		want = copyMap(want)
+		want["after"] = "crash"
+		if got := dump(t, s); !reflect.DeepEqual(got, want) {
+			t.Errorf("\nlog cut at offset:%v, then written to \ngot= %q, \nwant = %q", cut, got, want)
+		}
+		s.Close() // nolint: errcheck
+		cleanup()
+	}
+
+	// a record that is corrupt while others follow it was not torn by a crash, so Open fails instead of dropping the others.
+	corrupt := append([]byte{}, log...)
+	corrupt[offsets[2]-1] ^= 0xff
+	crashed, cleanup := tempDir(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(filepath.Join(crashed, walFile), corrupt, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Open(crashed, Options{}); err == nil {
+		s.Close() // nolint: errcheck
+		t.Errorf("\nOpen() with a corrupt record in the middle of the log \nwanted an error")
+	}
+	got, err := ioutil.ReadFile(filepath.Join(crashed, walFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, corrupt) {
+		t.Errorf("\nOpen() with a corrupt record in the middle of the log \nchanged the log")
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := openStore(t, dir, Options{})
+	if err := s.Set([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set([]byte("baz"), []byte("qux")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, walFile)
+	log, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log[len(log)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, log, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, dir, Options{})
+	defer s.Close() // nolint: errcheck
+	if got, want := dump(t, s), map[string]string{"foo": "bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("\ncorrupt last record \ngot= %q, \nwant = %q", got, want)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := openStore(t, dir, Options{CompactAfter: 256})
+	want := map[string]string{}
+	for i := 0; i < 100; i++ {
+		k, val := fmt.Sprintf("key-%v", i%10), fmt.Sprintf("val-%v", i)
+		if err := s.Set([]byte(k), []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+		want[k] = val
+	}
+	info, err := os.Stat(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= 256 {
+		t.Errorf("\nlog size \ngot= %v, \nwanted less than %v", info.Size(), 256)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, dir, Options{})
+	if got := dump(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("\nreopened store \ngot= %q, \nwant = %q", got, want)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("crash before the log is emptied", func(t *testing.T) {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		s := openStore(t, dir, Options{CompactAfter: -1})
+		for _, k := range []string{"foo", "bar"} {
+			if err := s.Set([]byte(k), []byte("1")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Delete([]byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		want := dump(t, s)
+		log, err := ioutil.ReadFile(filepath.Join(dir, walFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// the snapshot was renamed into place, but the log was not emptied.
+		if err := ioutil.WriteFile(filepath.Join(dir, walFile), log, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, snapshotFile+".tmp"), []byte("half written"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		s = openStore(t, dir, Options{})
+		defer s.Close() // nolint: errcheck
+		if got := dump(t, s); !reflect.DeepEqual(got, want) {
+			t.Errorf("\nreopened store \ngot= %q, \nwant = %q", got, want)
+		}
+	})
+}
+
+func TestPropose(t *testing.T) {
+	dirs := []string{}
+	stores := []*Store{}
+	nodes := []*kshaka.Node{}
+	for i := uint64(1); i <= 3; i++ {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		s := openStore(t, dir, Options{})
+		n := kshaka.NewNode(i, s)
+		n.AddTransport(&kshaka.InmemTransport{Node: n})
+		dirs = append(dirs, dir)
+		stores = append(stores, s)
+		nodes = append(nodes, n)
+	}
+	kshaka.MingleNodes(nodes...)
+
+	key, val := []byte("foo"), []byte("bar")
+	_, err := nodes[0].Propose(key, func(current []byte) ([]byte, error) { return val, nil })
+	if err != nil {
+		t.Fatalf("\nnode.Propose() \nerr = %v", err)
+	}
+
+	// the acceptors restart from their directories.
+	nodes = []*kshaka.Node{}
+	for i, dir := range dirs {
+		if err := stores[i].Close(); err != nil {
+			t.Fatal(err)
+		}
+		s := openStore(t, dir, Options{})
+		defer s.Close() // nolint: errcheck
+		n := kshaka.NewNode(uint64(i+1), s)
+		n.AddTransport(&kshaka.InmemTransport{Node: n})
+		nodes = append(nodes, n)
+	}
+	kshaka.MingleNodes(nodes...)
+	newState, err := nodes[1].Read(key)
+	if err != nil {
+		t.Fatalf("\nnode.Read() \nerr = %v", err)
+	}
+	if string(newState) != string(val) {
+		t.Errorf("\nnode.Read() \ngot= %s, \nwant = %s", newState, val)
+	}
+}